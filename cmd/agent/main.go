@@ -22,7 +22,32 @@ func main() {
 			Path string `json:"path"`
 			TLS  bool   `json:"tls"`
 			SNI  string `json:"sni"`
+			Transport string `json:"transport"`
+			Security string `json:"security"`
+			HostHeader string `json:"host_header"`
+			Obfs string `json:"obfs"`
 			TimeoutMS int64 `json:"timeout_ms"`
+			Retries int `json:"retries"`
+			BackoffInitialMS int64 `json:"backoff_initial_ms"`
+			BackoffMaxMS int64 `json:"backoff_max_ms"`
+			PreferHTTP bool `json:"prefer_http"`
+			HTTPProbePaths []string `json:"http_probe_paths"`
+			HandshakeURL string `json:"handshake_url"`
+			Cipher string `json:"cipher"`
+			Password string `json:"password"`
+			Plugin string `json:"plugin"`
+			FetchURL string `json:"fetch_url"`
+			FetchExpectStatus int `json:"fetch_expect_status"`
+			FetchExpectBody string `json:"fetch_expect_body"`
+			FetchExpectSHA256 string `json:"fetch_expect_sha256"`
+			FetchMaxBodyBytes int64 `json:"fetch_max_body_bytes"`
+			UUID string `json:"uuid"`
+			AlterID int `json:"alter_id"`
+			Flow string `json:"flow"`
+			ServiceName string `json:"service_name"`
+			HeaderType string `json:"header_type"`
+			PublicKey string `json:"public_key"`
+			ShortID string `json:"short_id"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "bad_json", 400); return
@@ -30,8 +55,20 @@ func main() {
 		ctx := r.Context()
 		res := probe.LocalOrigin{}.ProbeNode(ctx, probe.Node{
 			ID: req.ID, Raw: req.Raw, Proto: req.Proto, Host: req.Host, Port: req.Port, Path: req.Path, TLS: req.TLS, SNI: req.SNI,
-		}, probe.Options{Timeout: time.Duration(req.TimeoutMS)*time.Millisecond})
-		out := map[string]any{"success": res.Success, "latency_ms": res.Latency.Milliseconds(), "method": res.Method, "err": res.Err}
+			Transport: req.Transport, Security: req.Security, HostHeader: req.HostHeader, Obfs: req.Obfs,
+			Cipher: req.Cipher, Password: req.Password, Plugin: req.Plugin,
+			UUID: req.UUID, AlterID: req.AlterID, Flow: req.Flow, ServiceName: req.ServiceName, HeaderType: req.HeaderType,
+			PublicKey: req.PublicKey, ShortID: req.ShortID,
+		}, probe.Options{
+			Timeout: time.Duration(req.TimeoutMS)*time.Millisecond, Retries: req.Retries,
+			BackoffInitial: time.Duration(req.BackoffInitialMS)*time.Millisecond,
+			BackoffMax: time.Duration(req.BackoffMaxMS)*time.Millisecond,
+			PreferHTTP: req.PreferHTTP, HTTPProbePaths: req.HTTPProbePaths, HandshakeURL: req.HandshakeURL,
+			Fetch: probe.FetchCheck{URL: req.FetchURL, ExpectStatus: req.FetchExpectStatus, ExpectBody: req.FetchExpectBody,
+				ExpectSHA256: req.FetchExpectSHA256, MaxBodyBytes: req.FetchMaxBodyBytes},
+		})
+		out := map[string]any{"success": res.Success, "latency_ms": res.Latency.Milliseconds(), "method": res.Method, "err": res.Err,
+			"path": res.Path, "attempts": res.Attempts, "ttfb_ms": res.TTFB.Milliseconds()}
 		_ = json.NewEncoder(w).Encode(out)
 	})
 	addr := ":8081"
@@ -2,13 +2,16 @@ package main
 
 import (
 	"context"
-	"crypto/sha1"
-	"encoding/base64"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math/rand"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -16,10 +19,12 @@ import (
 	"github.com/yasi-python/go/pkg/api"
 	"github.com/yasi-python/go/pkg/config"
 	"github.com/yasi-python/go/pkg/decision"
+	"github.com/yasi-python/go/pkg/export"
 	"github.com/yasi-python/go/pkg/logger"
 	"github.com/yasi-python/go/pkg/metrics"
 	"github.com/yasi-python/go/pkg/probe"
 	"github.com/yasi-python/go/pkg/storage"
+	"github.com/yasi-python/go/pkg/uri"
 )
 
 type Manager struct {
@@ -27,11 +32,17 @@ type Manager struct {
 	log    *logger.Logger
 	db     *storage.DB
 	origins []probe.Origin
+	limiter *probe.Limiter
 	snapDir string
 
 	// state
 	totalDeletionsToday int
 	dayStart time.Time
+
+	mu         sync.Mutex
+	outputMode string
+	published  []export.Entry // set chosen by the last export cycle, served on /sub
+	lastGood   []export.Entry // last healthy export set, for keep_last
 }
 
 func NewManager(cfg *config.Config, log *logger.Logger, db *storage.DB) *Manager {
@@ -45,8 +56,15 @@ func NewManager(cfg *config.Config, log *logger.Logger, db *storage.DB) *Manager
 			})
 		}
 	}
+	limiter := probe.NewLimiter(cfg.Service.RateLimitPerTargetPerMinute, cfg.Service.RateLimitBurst, cfg.Service.RateLimitScopes)
+	if limiter != nil {
+		limiter.OnWait = func(scope string, d time.Duration) {
+			metrics.ThrottledWaits.WithLabelValues(scope).Inc()
+			metrics.ThrottleWait.Observe(d.Seconds())
+		}
+	}
 	return &Manager{
-		cfg: cfg, log: log, db: db, origins: origins, snapDir: cfg.Service.SnapshotsDir,
+		cfg: cfg, log: log, db: db, origins: origins, limiter: limiter, snapDir: cfg.Service.SnapshotsDir,
 		dayStart: midnightUTC(time.Now()),
 	}
 }
@@ -71,6 +89,9 @@ func (m *Manager) ListConfigs() any {
 func (m *Manager) Reprobe(id string) error {
 	c, err := m.db.GetConfig(id)
 	if err != nil { return err }
+	if !m.takeProbeToken(*c) {
+		return nil
+	}
 	return m.probeOnceAndDecide(*c)
 }
 
@@ -106,106 +127,206 @@ func (m *Manager) Rollback(id string) error {
 	return m.db.PutConfig(*c)
 }
 
-func parseMinimal(raw string) (proto, host string, port int, path string, tlsOn bool, sni string) {
-	l := strings.TrimSpace(strings.ToLower(raw))
-	_ = l
-	// very light parse: try find host:port first
-	host, port = findHostPort(raw)
-	// protocol
-	if strings.HasPrefix(l, "vmess://") { proto = "vmess" }
-	if strings.HasPrefix(l, "vless://") { proto = "vless" }
-	if strings.HasPrefix(l, "trojan://") { proto = "trojan" }
-	if strings.HasPrefix(l, "ss://") { proto = "ss" }
-	if strings.HasPrefix(l, "socks5://") { proto = "socks5" }
-	// path/sni heuristics
-	if i := strings.Index(raw, "path="); i >= 0 {
-		path = "/" // minimal
-	}
-	if strings.Contains(raw, "security=tls") || strings.Contains(raw, "tls=") {
-		tlsOn = true
-	}
-	return
-}
-
-func findHostPort(raw string) (string, int) {
-	// crude host:port detector
-	for _, seg := range strings.Fields(strings.ReplaceAll(raw, "/", " ")) {
-		if i := strings.LastIndex(seg, ":"); i > 0 && i < len(seg)-1 {
-			h := seg[:i]
-			var p int
-			_, err := fmt.Sscanf(seg[i+1:], "%d", &p)
-			if err == nil && p > 0 && p < 65536 {
-				return trimHost(h), p
-			}
+// probeNodeFor builds the probe target from the persisted node model.
+func probeNodeFor(c storage.ConfigRecord) probe.Node {
+	sni := c.SNI
+	if sni == "" {
+		sni = c.HostHeader
+	}
+	if sni == "" {
+		sni = c.Host
+	}
+	pn := probe.Node{
+		ID: c.ID, Raw: c.Raw, Proto: c.Proto, Host: c.Host, Port: c.Port,
+		Path: c.Path, TLS: c.TLS(), SNI: sni,
+		Transport: c.Transport, Security: c.Security, HostHeader: c.HostHeader, Obfs: c.Obfs,
+		ServiceName: c.ServiceName,
+	}
+	// credentials are not persisted outside Raw
+	if n, err := uri.Parse(c.Raw); err == nil {
+		pn.Cipher, pn.Password, pn.Plugin = n.Cipher, n.Password, n.Plugin
+		pn.UUID, pn.AlterID, pn.Flow, pn.HeaderType = n.UUID, n.AlterID, n.Flow, n.HeaderType
+		if n.Security == "reality" {
+			pn.PublicKey, pn.ShortID = n.PublicKey, n.ShortID
 		}
 	}
-	return "", 0
-}
-
-func trimHost(h string) string {
-	h = strings.Trim(h, "[]")
-	h = strings.TrimSuffix(h, ",")
-	return h
-}
-
-func idFor(raw string) string {
-	h := sha1.Sum([]byte(raw))
-	return base64.RawURLEncoding.EncodeToString(h[:])
+	return pn
 }
 
 func (m *Manager) mergeAndStore(ctx context.Context) ([]storage.ConfigRecord, error) {
 	f := subscription.HTTPFetcher{}
 	all := []string{}
-	for _, u := range m.cfg.Subscriptions.Sources {
+	source := map[string]string{}   // raw -> first source it was seen in
+	tags := map[string][]string{} // raw -> tags of every source carrying it
+	for _, src := range m.cfg.Subscriptions.Sources {
+		u := src.URL
 		txt, err := f.Fetch(ctx, u)
 		if err != nil {
 			m.log.Warn("fetch_failed", "url", u, "err", err.Error())
 			continue
 		}
-		nodes := subscription.ExtractNodes(txt)
+		nodes, format, skipped, err := subscription.Decode(txt)
+		if err != nil {
+			m.log.Warn("decode_failed", "url", u, "format", string(format), "err", err.Error())
+			continue
+		}
+		if skipped > 0 {
+			metrics.ParseRejects.WithLabelValues(string(format), "unmapped_entry").Add(float64(skipped))
+		}
 		if m.cfg.Subscriptions.PerSourceLimit > 0 && len(nodes) > m.cfg.Subscriptions.PerSourceLimit {
 			nodes = nodes[:m.cfg.Subscriptions.PerSourceLimit]
 		}
+		for _, n := range nodes {
+			if _, ok := source[n]; !ok {
+				source[n] = u
+			}
+			tags[n] = addTags(tags[n], src.Tags)
+		}
 		all = append(all, nodes...)
 	}
-	// dedupe
-	seen := map[string]bool{}
-	candidates := []string{}
-	for _, n := range all {
-		if !seen[n] {
-			seen[n] = true
-			candidates = append(candidates, n)
+	// dedupe on the canonical node identity so remark/param-order/encoding
+	// variants of one server collapse into a single record
+	type logical struct {
+		id      string
+		node    *uri.Node
+		aliases []storage.Alias
+		tags    []string
+	}
+	byID := map[string]*logical{}
+	candidates := []*logical{}
+	rejected := 0
+	for _, raw := range all {
+		n, err := uri.Parse(raw)
+		if err != nil {
+			rejected++
+			metrics.ParseRejects.WithLabelValues(uri.Scheme(raw), rejectReason(err)).Inc()
+			m.log.Debug("parse_failed", "raw_prefix", uri.Scheme(raw), "err", err.Error())
+			continue
+		}
+		alias := storage.Alias{Raw: n.Raw, Remark: n.Remark, SourceURL: source[raw]}
+		id := n.ID()
+		if l, ok := byID[id]; ok {
+			l.aliases = append(l.aliases, alias)
+			l.tags = addTags(l.tags, tags[raw])
+			continue
 		}
 		if m.cfg.Subscriptions.MergedLimit > 0 && len(candidates) >= m.cfg.Subscriptions.MergedLimit {
-			break
+			continue
 		}
+		l := &logical{id: id, node: n, aliases: []storage.Alias{alias}, tags: addTags(nil, tags[raw])}
+		byID[id] = l
+		candidates = append(candidates, l)
 	}
 	out := []storage.ConfigRecord{}
-	for _, raw := range candidates {
-		id := idFor(raw)
-		proto, host, port, path, tlsOn, sni := parseMinimal(raw)
-		cr := storage.ConfigRecord{ID: id, Raw: raw, Proto: proto, Host: host, Port: port}
-		_ = path; _ = tlsOn; _ = sni // stored minimal
+	now := time.Now().Unix()
+	for _, l := range candidates {
+		// keep operator/decision state, first-seen and the canonical raw
+		// across refreshes
+		cr := storage.ConfigRecord{ID: l.id, Raw: l.node.Raw, FirstSeenUnix: now, SourceURL: l.aliases[0].SourceURL}
+		if prev, err := m.db.GetConfig(l.id); err == nil {
+			cr = *prev
+		} else {
+			cr.ApplyNode(l.node)
+		}
+		for _, a := range l.aliases {
+			cr.AddAlias(a)
+		}
+		cr.LastSeenUnix = now
+		cr.Tags = l.tags
 		if err := m.db.PutConfig(cr); err == nil {
 			out = append(out, cr)
 		}
 	}
+	m.log.Info("merged", "raw", len(all), "stored", len(out), "rejected", rejected)
 	return out, nil
 }
 
-func (m *Manager) probeOnceAndDecide(c storage.ConfigRecord) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(m.cfg.Probe.TimeoutMS)*time.Millisecond)
+// addTags appends the tags not yet in dst.
+func addTags(dst, add []string) []string {
+	for _, t := range add {
+		found := false
+		for _, d := range dst {
+			found = found || d == t
+		}
+		if !found {
+			dst = append(dst, t)
+		}
+	}
+	return dst
+}
+
+// rejectReason maps a parse error to a low-cardinality metric label.
+func rejectReason(err error) string {
+	var ce *uri.CipherError
+	if errors.As(err, &ce) {
+		if ce.Insecure {
+			return "insecure_cipher"
+		}
+		return "unsupported_cipher"
+	}
+	switch {
+	case errors.Is(err, uri.ErrUnsupportedScheme):
+		return "unsupported_scheme"
+	case errors.Is(err, uri.ErrMissingHost):
+		return "missing_host"
+	case errors.Is(err, uri.ErrInvalidPort):
+		return "invalid_port"
+	}
+	return "malformed"
+}
+
+// probeBudget gives each origin room for every attempt plus the backoff
+// between them.
+func probeBudget(opt probe.Options) time.Duration {
+	return time.Duration(opt.Retries+1)*opt.Timeout + time.Duration(opt.Retries)*opt.BackoffMax
+}
+
+// takeProbeToken takes c's rate-limit token for one probe across all
+// origins. It is false when the target's budget is spent for this round;
+// the node is then skipped rather than counted as failed.
+func (m *Manager) takeProbeToken(c storage.ConfigRecord) bool {
+	if c.Host == "" {
+		return true
+	}
+	opt := m.probeOptions()
+	ctx, cancel := context.WithTimeout(context.Background(), probeBudget(opt))
 	defer cancel()
-	opt := probe.Options{Timeout: time.Duration(m.cfg.Probe.TimeoutMS) * time.Millisecond}
+	if err := m.limiter.Wait(ctx, c.Host, opt.Timeout); err != nil {
+		metrics.TotalProbes.WithLabelValues("throttled").Inc()
+		return false
+	}
+	return true
+}
+
+// probeOnceAndDecide probes c on every origin and applies the decision.
+// Callers take c's token with takeProbeToken first.
+func (m *Manager) probeOnceAndDecide(c storage.ConfigRecord) error {
+	opt := m.probeOptions()
+	budget := probeBudget(opt)
 	// run across origins; we require consensus: all must succeed to record success
 	successAll := true
 	latAgg := time.Duration(0)
-	tried := 0
+	tried, ok, attempts := 0, 0, 0
 	for _, o := range m.origins {
 		tried++
-		n := probe.Node{ID: c.ID, Raw: c.Raw, Proto: c.Proto, Host: c.Host, Port: c.Port}
-		res := o.ProbeNode(ctx, n, opt)
+		ctx, cancel := context.WithTimeout(context.Background(), budget)
+		res := o.ProbeNode(ctx, probeNodeFor(c), opt)
+		cancel()
+		if res.Err == probe.ErrUntested.Error() {
+			// no verdict either: recording it would build a perfect
+			// success record for a node nobody reached
+			metrics.TotalProbes.WithLabelValues("untested").Inc()
+			return nil
+		}
+		m.log.Debug("probe_result", "id", c.ID, "origin", o.Name(), "success", res.Success,
+			"method", res.Method, "path", res.Path, "attempts", res.Attempts, "err", res.Err,
+			"latency_ms", res.Latency.Milliseconds(), "ttfb_ms", res.TTFB.Milliseconds())
+		attempts += max(res.Attempts, 1)
+		if res.Attempts > 1 {
+			metrics.ProbeRetries.Add(float64(res.Attempts - 1))
+		}
 		if res.Success {
+			ok++
 			metrics.TotalProbes.WithLabelValues("success").Inc()
 			metrics.AvgLatency.Observe(res.Latency.Seconds())
 			latAgg += res.Latency
@@ -214,7 +335,13 @@ func (m *Manager) probeOnceAndDecide(c storage.ConfigRecord) error {
 			successAll = false
 		}
 	}
-	statsRec, err := m.db.UpdateStatsForProbe(c.ID, successAll && tried>0)
+	var lat time.Duration
+	if successAll && tried > 0 {
+		lat = latAgg / time.Duration(tried)
+	}
+	statsRec, err := m.db.UpdateStatsForProbe(c.ID, storage.ProbeOutcome{
+		Success: successAll && tried > 0, Latency: lat, OriginsOK: ok, OriginsTried: tried, Attempts: attempts,
+	})
 	if err != nil { return err }
 	// Decision
 	dec := decision.Evaluate(decision.DecisionInput{
@@ -253,44 +380,183 @@ func (m *Manager) probeOnceAndDecide(c storage.ConfigRecord) error {
 	return nil
 }
 
+// shuffleConfigs randomises probe order so that, when a shared target is
+// rate limited, the same nodes are not the ones skipped every round.
+func shuffleConfigs(cs []storage.ConfigRecord) {
+	rand.Shuffle(len(cs), func(i, j int) { cs[i], cs[j] = cs[j], cs[i] })
+}
+
+// probeOptions maps the probe section of the config; retries default to
+// none and the backoff to 300ms..3s.
+func (m *Manager) probeOptions() probe.Options {
+	pc := m.cfg.Probe
+	opt := probe.Options{
+		Timeout:        time.Duration(pc.TimeoutMS) * time.Millisecond,
+		Retries:        max(pc.Retries, 0),
+		BackoffInitial: time.Duration(pc.BackoffInitialMS) * time.Millisecond,
+		BackoffMax:     time.Duration(pc.BackoffMaxMS) * time.Millisecond,
+		PreferHTTP:     pc.PreferHTTPIfWSOrPath,
+		HTTPProbePaths: pc.HTTPProbePaths,
+		HandshakeURL:   pc.HandshakeURL,
+		Fetch: probe.FetchCheck{
+			URL: pc.Fetch.URL, ExpectStatus: pc.Fetch.ExpectStatus, ExpectBody: pc.Fetch.ExpectBody,
+			ExpectSHA256: pc.Fetch.ExpectSHA256, MaxBodyBytes: pc.Fetch.MaxBodyBytes,
+		},
+	}
+	if opt.BackoffInitial <= 0 {
+		opt.BackoffInitial = 300 * time.Millisecond
+	}
+	if opt.BackoffMax < opt.BackoffInitial {
+		opt.BackoffMax = max(3*time.Second, opt.BackoffInitial)
+	}
+	return opt
+}
+
 // quickProbeAll runs probes for all configs with bounded concurrency.
 // It invokes probeOnceAndDecide for each config and waits for them to finish.
+// A probe takes its rate-limit token before a worker slot, so nodes queued
+// behind a busy target do not hold workers while they wait.
 func (m *Manager) quickProbeAll(ctx context.Context) {
 	cs, _ := m.db.ListConfigs()
+	shuffleConfigs(cs)
 	sem := make(chan struct{}, m.cfg.Service.Concurrency)
+	var wg sync.WaitGroup
 	for _, c := range cs {
 		if c.Deleted {
 			continue
 		}
-		sem <- struct{}{}
+		wg.Add(1)
 		go func(cc storage.ConfigRecord) {
+			defer wg.Done()
+			if !m.takeProbeToken(cc) {
+				return
+			}
+			sem <- struct{}{}
 			defer func(){ <-sem }()
 			_ = m.probeOnceAndDecide(cc)
 		}(c)
 	}
-	// drain to wait for goroutines to finish
-	for i := 0; i < cap(sem); i++ {
-		sem <- struct{}{}
+	wg.Wait()
+}
+
+// outputProfile is one named view of the healthy set and the files it is
+// written to (format -> path).
+type outputProfile struct {
+	name    string
+	filter  export.Filter
+	sort    string
+	limit   int
+	remark  string
+	outputs map[string]string
+}
+
+// profiles returns the legacy subscriptions.outputs as profile "default",
+// followed by the configured profiles.
+func (m *Manager) profiles() []outputProfile {
+	outs := m.cfg.Subscriptions.Outputs
+	ps := []outputProfile{{name: "default", remark: outs.RemarkTemplate, outputs: map[string]string{
+		export.FormatPlain: outs.PlainPath, export.FormatBase64: outs.Base64Path,
+		export.FormatClash: outs.ClashPath, export.FormatSingBox: outs.SingBoxPath, export.FormatXray: outs.XrayPath,
+	}}}
+	for _, p := range m.cfg.Subscriptions.Profiles {
+		f := p.Filter
+		ps = append(ps, outputProfile{
+			name: p.Name, sort: p.Sort, limit: p.Limit, remark: p.Remark, outputs: p.Outputs,
+			filter: export.Filter{
+				Protocols: f.Protocols, Transports: f.Transports, TLSOnly: f.TLSOnly, UDPOnly: f.UDPOnly,
+				MaxLatencyMS: f.MaxLatencyMS, MinSuccessRate: f.MinSuccessRate, Countries: f.Countries,
+				MinOrigins: f.MinOrigins, Tags: f.Tags, MinBytesPerSec: f.MinBytesPerSec,
+			},
+		})
 	}
+	return ps
 }
 
-// exportOutputsNow writes healthy configs to two files:
-// - plain text (one per line) at cfg.Subscriptions.Outputs.PlainPath
-// - base64-encoded combined text at cfg.Subscriptions.Outputs.Base64Path
-// If no healthy configs are found, it falls back to exporting merged configs (to avoid empty CI artifacts).
+// errNoHealthy is returned by exportOutputsNow under the "fail" policy.
+var errNoHealthy = errors.New("no_healthy_nodes")
+
+// exportOutputsNow writes every profile's healthy configs to its outputs
+// (plain, base64, Clash.Meta, sing-box, Xray). When too few nodes are
+// healthy the degraded policy applies, see exportSet. The chosen set and
+// mode are recorded for /healthz and /sub even without file outputs.
 func (m *Manager) exportOutputsNow() error {
-	now := time.Now()
-	plain := m.cfg.Subscriptions.Outputs.PlainPath
-	b64p := m.cfg.Subscriptions.Outputs.Base64Path
-	if plain == "" && b64p == "" {
-		// nothing configured
+	healthy, mode := m.exportSet(time.Now())
+	m.setOutputMode(mode, healthy)
+	if mode != outputHealthy {
+		m.log.Warn("output_degraded", "mode", mode, "exported", len(healthy))
+	}
+	ps := m.profiles()
+	configured := false
+	for _, p := range ps {
+		for _, path := range p.outputs {
+			configured = configured || path != ""
+		}
+	}
+	if !configured {
 		return nil
 	}
-	cs, _ := m.db.ListConfigs()
-	healthy := make([]string, 0, len(cs))
+	switch mode {
+	case outputKeepLast:
+		// previous files stay in place
+		return nil
+	case outputFailed:
+		return errNoHealthy
+	}
+	clash, client := m.clashOptions(), m.clientOptions()
+	var errs []error
+	for _, p := range ps {
+		es := export.Rename(export.Select(healthy, p.filter, p.sort, p.limit), p.remark)
+		written := 0
+		for format, path := range p.outputs {
+			if path == "" {
+				continue
+			}
+			changed := false
+			b, err := export.Render(format, es, clash, client)
+			if err == nil {
+				changed, err = m.writeOutput(path, b)
+			}
+			switch {
+			case err != nil:
+				metrics.OutputWrites.WithLabelValues(p.name, format, "error").Inc()
+				m.log.Error("output_write_failed", "profile", p.name, "format", format, "path", path, "err", err.Error())
+				errs = append(errs, fmt.Errorf("%s/%s: %w", p.name, format, err))
+			case changed:
+				written++
+				metrics.OutputWrites.WithLabelValues(p.name, format, "written").Inc()
+			default:
+				metrics.OutputWrites.WithLabelValues(p.name, format, "unchanged").Inc()
+			}
+		}
+		m.log.Info("outputs_written", "profile", p.name, "count", len(es), "files_changed", written)
+	}
+	return errors.Join(errs...)
+}
+
+// writeOutput atomically replaces path with b unless the last export
+// recorded in the state bucket already has this content (and the file is
+// still there), so unchanged outputs keep their mtime.
+func (m *Manager) writeOutput(path string, b []byte) (changed bool, err error) {
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+	if st, err := m.db.GetExportState(path); err == nil && st.Hash == hash {
+		if fi, err := os.Stat(path); err == nil && fi.Size() == int64(len(b)) {
+			return false, nil
+		}
+	}
+	if err := export.WriteAtomic(path, b, 0o644); err != nil {
+		return false, err
+	}
+	return true, m.db.PutExportState(storage.ExportState{
+		Path: path, Hash: hash, Bytes: len(b), WrittenUnix: time.Now().Unix(),
+	})
+}
 
-	// Criteria for healthy configs:
-	// Attempts > 0, Successes > 0, ConsecutiveFailures < 3, LastSuccess within 6 hours
+// healthyEntries returns the nodes worth exporting, fastest first:
+// Attempts > 0, Successes > 0, ConsecutiveFailures < 3, LastSuccess within 6 hours.
+func (m *Manager) healthyEntries(now time.Time) []export.Entry {
+	cs, _ := m.db.ListConfigs()
+	healthy := make([]export.Entry, 0, len(cs))
 	const fresh = 6 * time.Hour
 	for _, c := range cs {
 		if c.Deleted || c.Quarantine {
@@ -300,39 +566,213 @@ func (m *Manager) exportOutputsNow() error {
 		if err == nil && s.Attempts > 0 && s.Successes > 0 &&
 			s.ConsecutiveFailures < 3 &&
 			(now.Sub(time.Unix(s.LastSuccessUnix, 0)) <= fresh) {
-			healthy = append(healthy, c.Raw)
+			if e, ok := entryFor(c, s); ok {
+				healthy = append(healthy, e)
+			}
 		}
 	}
+	export.SortByLatency(healthy)
+	return healthy
+}
+
+// Output modes, reported in /healthz and v2mgr_output_mode.
+const (
+	outputHealthy  = "healthy"
+	outputKeepLast = "degraded_keep_last"
+	outputTopN     = "degraded_top_n"
+	outputAll      = "degraded_all"
+	outputFailed   = "failed"
+)
+
+var outputModes = []string{outputHealthy, outputKeepLast, outputTopN, outputAll, outputFailed}
 
-	// Fallback: export merged list if no healthy entries found (prevents empty artifacts in CI)
-	if len(healthy) == 0 {
+// exportSet returns the nodes to publish. Below subscriptions.degraded.min_healthy
+// healthy nodes the configured policy decides: keep_last (the last healthy
+// set, files untouched), top_n (best historical success rate), all, or fail.
+// It only reads state; exportOutputsNow records the outcome.
+func (m *Manager) exportSet(now time.Time) ([]export.Entry, string) {
+	healthy := m.healthyEntries(now)
+	dc := m.cfg.Subscriptions.Degraded
+	minHealthy := dc.MinHealthy
+	if minHealthy <= 0 {
+		minHealthy = 1
+	}
+	if len(healthy) >= minHealthy {
+		return healthy, outputHealthy
+	}
+	mode := outputKeepLast
+	var es []export.Entry
+	switch dc.Policy {
+	case "all", "top_n":
+		cs, _ := m.db.ListConfigs()
 		for _, c := range cs {
 			if c.Deleted || c.Quarantine {
 				continue
 			}
-			healthy = append(healthy, c.Raw)
+			s, _ := m.db.GetStats(c.ID)
+			if e, ok := entryFor(c, s); ok {
+				es = append(es, e)
+			}
 		}
+		mode = outputAll
+		if dc.Policy == "top_n" {
+			mode = outputTopN
+			n := dc.TopN
+			if n <= 0 {
+				n = 50
+			}
+			es = export.Select(es, export.Filter{}, export.SortSuccessRate, n)
+		} else {
+			export.SortByLatency(es)
+		}
+	case "fail":
+		mode = outputFailed
+	default:
+		m.mu.Lock()
+		es = m.lastGood
+		m.mu.Unlock()
 	}
+	return es, mode
+}
 
-	// write plain
-	if plain != "" {
-		if err := os.MkdirAll(filepath.Dir(plain), 0o755); err != nil {
-			m.log.Error("mkdir_outputs_plain", "err", err.Error())
+// setOutputMode records the outcome of an export cycle for /healthz, /sub
+// and metrics; a healthy set also becomes the keep_last fallback.
+func (m *Manager) setOutputMode(mode string, es []export.Entry) {
+	m.mu.Lock()
+	m.outputMode = mode
+	m.published = es
+	if mode == outputHealthy {
+		m.lastGood = es
+	}
+	m.mu.Unlock()
+	for _, x := range outputModes {
+		v := 0.0
+		if x == mode {
+			v = 1
 		}
-		_ = os.WriteFile(plain, []byte(strings.Join(healthy, "\n")+"\n"), 0o644)
+		metrics.OutputMode.WithLabelValues(x).Set(v)
 	}
-	// write base64
-	if b64p != "" {
-		if err := os.MkdirAll(filepath.Dir(b64p), 0o755); err != nil {
-			m.log.Error("mkdir_outputs_b64", "err", err.Error())
+}
+
+// Health feeds /healthz.
+func (m *Manager) Health() api.Health {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mode := m.outputMode
+	if mode == "" {
+		mode = "pending"
+	}
+	return api.Health{Output: mode, Degraded: mode != outputHealthy && mode != "pending"}
+}
+
+// Subscription serves /sub/{profile} from the set of the last export cycle,
+// the same one the file outputs were written from.
+func (m *Manager) Subscription(profile string) (*api.Subscription, error) {
+	for _, p := range m.profiles() {
+		if p.name != profile {
+			continue
+		}
+		sc := m.cfg.API.Subscription
+		hours := sc.UpdateIntervalHours
+		if hours <= 0 {
+			// round the fetch interval up to whole hours
+			hours = (m.cfg.Subscriptions.FetchIntervalSeconds + 3599) / 3600
 		}
-		comb := strings.Join(healthy, "\n")
-		b64 := base64.StdEncoding.EncodeToString([]byte(comb))
-		_ = os.WriteFile(b64p, []byte(b64), 0o644)
+		m.mu.Lock()
+		es, mode := m.published, m.outputMode
+		m.mu.Unlock()
+		if mode == "" || mode == outputFailed || (mode == outputKeepLast && es == nil) {
+			return nil, api.ErrNoOutput
+		}
+		return &api.Subscription{
+			Entries: export.Rename(export.Select(es, p.filter, p.sort, p.limit), p.remark),
+			Clash:   m.clashOptions(), Client: m.clientOptions(),
+			UpdateIntervalHours: hours, Userinfo: sc.Userinfo,
+		}, nil
 	}
+	return nil, api.ErrUnknownProfile
+}
 
-	m.log.Info("outputs_written", "count", len(healthy), "plain", plain, "base64", b64p)
-	return nil
+// entryFor pairs a record with its measurements; s may be nil.
+func entryFor(c storage.ConfigRecord, s *storage.StatsRecord) (export.Entry, bool) {
+	n, err := uri.Parse(c.Raw)
+	if err != nil {
+		return export.Entry{}, false
+	}
+	e := export.Entry{ID: c.ID, Node: n, Country: export.Country(n.Remark), Tags: c.Tags}
+	if s != nil {
+		e.LatencyMS, e.SuccessRate, e.OriginsOK = s.AvgLatencyMS, s.SuccessRate(), s.OriginsOK
+		e.BytesPerSec = s.ThroughputBPS
+	}
+	return e, true
+}
+
+func (m *Manager) clientOptions() export.ClientOptions {
+	cc := m.cfg.Subscriptions.Outputs.Client
+	return export.ClientOptions{TestURL: cc.TestURL, IntervalSeconds: cc.IntervalSeconds, ListenPort: cc.ListenPort}
+}
+
+func (m *Manager) clashOptions() export.ClashOptions {
+	cc := m.cfg.Subscriptions.Outputs.Clash
+	opt := export.ClashOptions{TestURL: cc.TestURL, IntervalSeconds: cc.IntervalSeconds, Rules: cc.Rules}
+	if cc.RulesFile != "" {
+		b, err := os.ReadFile(cc.RulesFile)
+		if err != nil {
+			m.log.Warn("clash_rules_file", "path", cc.RulesFile, "err", err.Error())
+			return opt
+		}
+		opt.Rules = nil
+		for _, l := range strings.Split(string(b), "\n") {
+			l = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(l), "- "))
+			if l != "" && !strings.HasPrefix(l, "#") {
+				opt.Rules = append(opt.Rules, l)
+			}
+		}
+	}
+	return opt
+}
+
+// measureThroughput downloads the configured test payload through the
+// lowest-latency healthy nodes that can be tunnelled, one at a time so the
+// measurements do not compete for the local link.
+func (m *Manager) measureThroughput(ctx context.Context) {
+	tc := m.cfg.Probe.Throughput
+	topN := tc.TopN
+	if topN <= 0 {
+		topN = 20
+	}
+	check := probe.ThroughputCheck{
+		URL: tc.URL, MaxBytes: tc.MaxBytes, MaxDuration: time.Duration(tc.MaxSeconds) * time.Second,
+	}
+	timeout := m.probeOptions().Timeout
+	measured, ok := 0, 0
+	for _, e := range m.healthyEntries(time.Now()) {
+		if measured >= topN || ctx.Err() != nil {
+			break
+		}
+		c, err := m.db.GetConfig(e.ID)
+		if err != nil {
+			continue
+		}
+		pn := probeNodeFor(*c)
+		if !probe.SupportsHandshake(pn) {
+			continue
+		}
+		if err := m.limiter.Wait(ctx, pn.Host, timeout); err != nil {
+			continue
+		}
+		measured++
+		r := probe.Throughput(ctx, pn, check, timeout)
+		if r.Err != "" {
+			metrics.ThroughputProbes.WithLabelValues("fail").Inc()
+			m.log.Debug("throughput_failed", "id", c.ID, "err", r.Err)
+		} else {
+			ok++
+			metrics.ThroughputProbes.WithLabelValues("ok").Inc()
+		}
+		_ = m.db.UpdateThroughput(c.ID, r.BytesPerSec)
+	}
+	m.log.Info("throughput_round", "measured", measured, "ok", ok)
 }
 
 func (m *Manager) backgroundLoop(ctx context.Context) {
@@ -340,6 +780,17 @@ func (m *Manager) backgroundLoop(ctx context.Context) {
 	tickerProbe := time.NewTicker(time.Duration(m.cfg.Service.ReprobeScheduleSeconds) * time.Second)
 	defer tickerFetch.Stop()
 	defer tickerProbe.Stop()
+	// the bandwidth stage runs on its own, slower schedule; off without a URL
+	var throughputC <-chan time.Time
+	if tc := m.cfg.Probe.Throughput; tc.URL != "" {
+		every := tc.ScheduleSeconds
+		if every <= 0 {
+			every = 21600
+		}
+		t := time.NewTicker(time.Duration(every) * time.Second)
+		defer t.Stop()
+		throughputC = t.C
+	}
 
 	// initial fetch + quick probe + export (helps CI pick up outputs immediately after start)
 	_, _ = m.mergeAndStore(ctx)
@@ -355,24 +806,11 @@ func (m *Manager) backgroundLoop(ctx context.Context) {
 			// after each fetch also quick probe + export
 			m.quickProbeAll(ctx)
 			_ = m.exportOutputsNow()
+		case <-throughputC:
+			m.measureThroughput(ctx)
+			_ = m.exportOutputsNow()
 		case <-tickerProbe.C:
-			cs, _ := m.db.ListConfigs()
-			// bounded concurrency probes
-			sem := make(chan struct{}, m.cfg.Service.Concurrency)
-			for _, c := range cs {
-				if c.Deleted {
-					continue
-				}
-				sem <- struct{}{}
-				go func(cc storage.ConfigRecord) {
-					defer func(){ <-sem }()
-					_ = m.probeOnceAndDecide(cc)
-				}(c)
-			}
-			// drain
-			for i := 0; i < cap(sem); i++ {
-				sem <- struct{}{}
-			}
+			m.quickProbeAll(ctx)
 			// after each round of probes, update outputs
 			_ = m.exportOutputsNow()
 		}
@@ -403,6 +841,7 @@ func main() {
 
 	// API server
 	apiSrv := api.New(mgr, cfg.Service.MetricsPath, cfg.Service.HealthzPath)
+	apiSrv.SubToken = cfg.API.Subscription.Token
 	go func(){
 		if err := apiSrv.Start(cfg.Service.HTTPListen); err != nil {
 			log.Error("api_start", "err", err.Error())
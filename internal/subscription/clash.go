@@ -0,0 +1,232 @@
+package subscription
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/yasi-python/go/pkg/uri"
+)
+
+// clashProxy covers the fields of Clash / Clash.Meta (mihomo) proxy entries
+// that map onto uri.Node. Unknown keys are ignored.
+type clashProxy struct {
+	Name     string  `yaml:"name"`
+	Type     string  `yaml:"type"`
+	Server   string  `yaml:"server"`
+	Port     flexInt `yaml:"port"`
+	Ports    string  `yaml:"ports"`
+	UUID     string  `yaml:"uuid"`
+	AlterID  flexInt `yaml:"alterId"`
+	Cipher   string  `yaml:"cipher"`
+	Password string  `yaml:"password"`
+	Flow     string  `yaml:"flow"`
+
+	TLS               bool     `yaml:"tls"`
+	SNI               string   `yaml:"sni"`
+	ServerName        string   `yaml:"servername"`
+	ALPN              []string `yaml:"alpn"`
+	SkipCertVerify    bool     `yaml:"skip-cert-verify"`
+	ClientFingerprint string   `yaml:"client-fingerprint"`
+	RealityOpts       struct {
+		PublicKey string `yaml:"public-key"`
+		ShortID   string `yaml:"short-id"`
+	} `yaml:"reality-opts"`
+
+	Network string `yaml:"network"`
+	WSOpts  struct {
+		Path    string            `yaml:"path"`
+		Headers map[string]string `yaml:"headers"`
+	} `yaml:"ws-opts"`
+	GRPCOpts struct {
+		ServiceName string `yaml:"grpc-service-name"`
+	} `yaml:"grpc-opts"`
+	H2Opts struct {
+		Host []string `yaml:"host"`
+		Path string   `yaml:"path"`
+	} `yaml:"h2-opts"`
+
+	Plugin     string         `yaml:"plugin"`
+	PluginOpts map[string]any `yaml:"plugin-opts"`
+
+	Obfs         string `yaml:"obfs"`
+	ObfsPassword string `yaml:"obfs-password"`
+
+	CongestionController string `yaml:"congestion-controller"`
+	UDPRelayMode         string `yaml:"udp-relay-mode"`
+}
+
+// flexInt accepts both 443 and "443".
+type flexInt int
+
+func (f *flexInt) UnmarshalYAML(v *yaml.Node) error {
+	s := strings.Trim(strings.TrimSpace(v.Value), `"'`)
+	if s == "" {
+		*f = 0
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*f = flexInt(n)
+	return nil
+}
+
+// LooksLikeClash reports whether body is a Clash config or provider file.
+func LooksLikeClash(body string) bool {
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(strings.TrimRight(line, "\r"), "proxies:") {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeClash converts the proxies of a Clash YAML document into share
+// links. Entries of unsupported types or with invalid fields are skipped
+// and counted so callers can report them.
+func DecodeClash(body string) ([]string, int, error) {
+	var doc struct {
+		Proxies []yaml.Node `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal([]byte(body), &doc); err != nil {
+		return nil, 0, err
+	}
+	out := []string{}
+	skipped := 0
+	for _, raw := range doc.Proxies {
+		var p clashProxy
+		if err := raw.Decode(&p); err != nil {
+			skipped++
+			continue
+		}
+		n, err := p.node()
+		if err != nil {
+			skipped++
+			continue
+		}
+		out = append(out, n.Raw)
+	}
+	return out, skipped, nil
+}
+
+var errClashType = errors.New("clash_unsupported_type")
+
+func (p *clashProxy) node() (*uri.Node, error) {
+	n := &uri.Node{
+		Host: p.Server, Port: int(p.Port), Remark: p.Name,
+		Transport: "tcp", Security: "none",
+		SNI: firstNonEmpty(p.SNI, p.ServerName), ALPN: p.ALPN,
+		AllowInsecure: p.SkipCertVerify, Fingerprint: p.ClientFingerprint,
+	}
+	switch strings.ToLower(p.Type) {
+	case "ss":
+		n.Proto, n.Cipher, n.Password = "ss", strings.ToLower(p.Cipher), p.Password
+		n.SNI, n.ALPN, n.Fingerprint = "", nil, ""
+		if err := p.ssPlugin(n); err != nil {
+			return nil, err
+		}
+	case "vmess":
+		n.Proto, n.UUID, n.AlterID, n.Cipher = "vmess", p.UUID, int(p.AlterID), firstNonEmpty(p.Cipher, "auto")
+		p.stream(n)
+	case "vless":
+		n.Proto, n.UUID, n.Cipher, n.Flow = "vless", p.UUID, "none", p.Flow
+		p.stream(n)
+	case "trojan":
+		n.Proto, n.Password = "trojan", p.Password
+		p.stream(n)
+		if n.Security == "none" {
+			// trojan is always TLS in Clash; the tls key is vmess/vless only
+			n.Security = "tls"
+		}
+	case "hysteria2", "hy2":
+		n.Proto, n.Password, n.Transport, n.Security = "hysteria2", p.Password, "quic", "tls"
+		n.Obfs, n.ObfsParam, n.Ports = p.Obfs, p.ObfsPassword, p.Ports
+		if f := strings.FieldsFunc(p.Ports, func(r rune) bool { return r == ',' || r == '-' }); n.Port == 0 && len(f) > 0 {
+			n.Port, _ = strconv.Atoi(f[0])
+		}
+	case "tuic":
+		n.Proto, n.UUID, n.Password, n.Transport, n.Security = "tuic", p.UUID, p.Password, "quic", "tls"
+		n.CongestionControl, n.UDPRelayMode = p.CongestionController, p.UDPRelayMode
+	default:
+		return nil, errClashType
+	}
+	// round-trip through the link form so the same validation applies as
+	// for URI-list sources
+	return uri.Parse(n.String())
+}
+
+// stream maps network/*-opts and tls/reality-opts for vmess, vless, trojan.
+func (p *clashProxy) stream(n *uri.Node) {
+	switch strings.ToLower(p.Network) {
+	case "ws":
+		n.Transport, n.Path = "ws", firstNonEmpty(p.WSOpts.Path, "/")
+		for k, v := range p.WSOpts.Headers {
+			if strings.EqualFold(k, "host") {
+				n.HostHeader = v
+			}
+		}
+	case "grpc":
+		n.Transport, n.ServiceName = "grpc", p.GRPCOpts.ServiceName
+	case "h2", "http":
+		n.Transport, n.Path = "h2", firstNonEmpty(p.H2Opts.Path, "/")
+		if len(p.H2Opts.Host) > 0 {
+			n.HostHeader = p.H2Opts.Host[0]
+		}
+	}
+	if p.TLS {
+		n.Security = "tls"
+	}
+	if p.RealityOpts.PublicKey != "" {
+		n.Security, n.PublicKey, n.ShortID = "reality", p.RealityOpts.PublicKey, p.RealityOpts.ShortID
+	}
+}
+
+// ssPlugin renders plugin-opts into the SIP003 ;-separated form.
+func (p *clashProxy) ssPlugin(n *uri.Node) error {
+	opt := func(k string) string {
+		if v, ok := p.PluginOpts[k]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+		return ""
+	}
+	switch p.Plugin {
+	case "":
+		return nil
+	case "obfs":
+		n.Plugin = "obfs-local"
+		n.PluginOpts = "obfs=" + opt("mode")
+		if h := opt("host"); h != "" {
+			n.PluginOpts += ";obfs-host=" + h
+		}
+	case "v2ray-plugin":
+		n.Plugin = "v2ray-plugin"
+		parts := []string{"mode=" + firstNonEmpty(opt("mode"), "websocket")}
+		if opt("tls") == "true" {
+			parts = append(parts, "tls")
+		}
+		if h := opt("host"); h != "" {
+			parts = append(parts, "host="+h)
+		}
+		if pth := opt("path"); pth != "" {
+			parts = append(parts, "path="+pth)
+		}
+		n.PluginOpts = strings.Join(parts, ";")
+	default:
+		return errClashType
+	}
+	return nil
+}
+
+func firstNonEmpty(vs ...string) string {
+	for _, v := range vs {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
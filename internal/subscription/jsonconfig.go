@@ -0,0 +1,380 @@
+package subscription
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/yasi-python/go/pkg/uri"
+)
+
+// outbound types that carry no server and are ignored without counting
+var nonProxyOutbounds = map[string]bool{
+	"direct": true, "block": true, "dns": true, "selector": true, "urltest": true,
+	"freedom": true, "blackhole": true, "loopback": true,
+}
+
+var errJSONType = errors.New("json_unsupported_outbound")
+
+// LooksLikeJSONConfig reports whether body is a JSON document (or array of
+// documents) that could hold sing-box or Xray outbounds.
+func LooksLikeJSONConfig(body string) bool {
+	t := strings.TrimSpace(body)
+	return (strings.HasPrefix(t, "{") || strings.HasPrefix(t, "[")) && strings.Contains(t, `"outbounds"`)
+}
+
+// DecodeJSONConfig extracts every proxy outbound of a sing-box
+// (outbounds[].type) or Xray (outbounds[].protocol) configuration. Some
+// sources publish an array of full Xray configs; those are flattened.
+func DecodeJSONConfig(body string) ([]string, Format, int, error) {
+	type document struct {
+		Outbounds []json.RawMessage `json:"outbounds"`
+	}
+	var docs []document
+	t := strings.TrimSpace(body)
+	if strings.HasPrefix(t, "[") {
+		if err := json.Unmarshal([]byte(t), &docs); err != nil {
+			return nil, FormatXray, 0, err
+		}
+	} else {
+		var d document
+		if err := json.Unmarshal([]byte(t), &d); err != nil {
+			return nil, FormatSingBox, 0, err
+		}
+		docs = append(docs, d)
+	}
+	format := Format("")
+	out := []string{}
+	skipped := 0
+	for _, d := range docs {
+		for _, raw := range d.Outbounds {
+			var head struct {
+				Type     string `json:"type"`
+				Protocol string `json:"protocol"`
+			}
+			if json.Unmarshal(raw, &head) != nil {
+				skipped++
+				continue
+			}
+			var (
+				n   *uri.Node
+				err error
+			)
+			switch {
+			case head.Protocol != "":
+				if nonProxyOutbounds[head.Protocol] {
+					continue
+				}
+				format = FormatXray
+				n, err = xrayNode(raw)
+			case head.Type != "":
+				if nonProxyOutbounds[head.Type] {
+					continue
+				}
+				format = FormatSingBox
+				n, err = singBoxNode(raw)
+			default:
+				continue
+			}
+			if err != nil {
+				skipped++
+				continue
+			}
+			out = append(out, n.Raw)
+		}
+	}
+	if format == "" {
+		format = FormatSingBox
+	}
+	return out, format, skipped, nil
+}
+
+type singBoxOutbound struct {
+	Type        string   `json:"type"`
+	Tag         string   `json:"tag"`
+	Server      string   `json:"server"`
+	ServerPort  int      `json:"server_port"`
+	ServerPorts []string `json:"server_ports"`
+	UUID        string   `json:"uuid"`
+	Password    string   `json:"password"`
+	Method      string   `json:"method"`
+	Security    string   `json:"security"`
+	AlterID     int      `json:"alter_id"`
+	Flow        string   `json:"flow"`
+	Username    string   `json:"username"`
+	Plugin      string   `json:"plugin"`
+	PluginOpts  string   `json:"plugin_opts"`
+	TLS         *struct {
+		Enabled    bool     `json:"enabled"`
+		ServerName string   `json:"server_name"`
+		Insecure   bool     `json:"insecure"`
+		ALPN       []string `json:"alpn"`
+		UTLS       struct {
+			Fingerprint string `json:"fingerprint"`
+		} `json:"utls"`
+		Reality struct {
+			Enabled   bool   `json:"enabled"`
+			PublicKey string `json:"public_key"`
+			ShortID   string `json:"short_id"`
+		} `json:"reality"`
+	} `json:"tls"`
+	Transport *struct {
+		Type        string            `json:"type"`
+		Path        string            `json:"path"`
+		Headers     map[string]string `json:"headers"`
+		Host        json.RawMessage   `json:"host"`
+		ServiceName string            `json:"service_name"`
+	} `json:"transport"`
+	Obfs *struct {
+		Type     string `json:"type"`
+		Password string `json:"password"`
+	} `json:"obfs"`
+	CongestionControl string   `json:"congestion_control"`
+	UDPRelayMode      string   `json:"udp_relay_mode"`
+	PrivateKey        string   `json:"private_key"`
+	PeerPublicKey     string   `json:"peer_public_key"`
+	PreSharedKey      string   `json:"pre_shared_key"`
+	LocalAddress      []string `json:"local_address"`
+	MTU               int      `json:"mtu"`
+	Reserved          []int    `json:"reserved"`
+}
+
+func singBoxNode(raw json.RawMessage) (*uri.Node, error) {
+	var o singBoxOutbound
+	if err := json.Unmarshal(raw, &o); err != nil {
+		return nil, err
+	}
+	n := &uri.Node{
+		Host: o.Server, Port: o.ServerPort, Remark: o.Tag,
+		Transport: "tcp", Security: "none",
+	}
+	switch o.Type {
+	case "shadowsocks":
+		n.Proto, n.Cipher, n.Password = "ss", strings.ToLower(o.Method), o.Password
+		n.Plugin, n.PluginOpts = o.Plugin, o.PluginOpts
+	case "vmess":
+		n.Proto, n.UUID, n.AlterID, n.Cipher = "vmess", o.UUID, o.AlterID, firstNonEmpty(o.Security, "auto")
+	case "vless":
+		n.Proto, n.UUID, n.Cipher, n.Flow = "vless", o.UUID, "none", o.Flow
+	case "trojan":
+		n.Proto, n.Password = "trojan", o.Password
+	case "hysteria2":
+		n.Proto, n.Password, n.Transport = "hysteria2", o.Password, "quic"
+		n.Ports = strings.ReplaceAll(strings.Join(o.ServerPorts, ","), ":", "-")
+		if o.Obfs != nil {
+			n.Obfs, n.ObfsParam = o.Obfs.Type, o.Obfs.Password
+		}
+	case "tuic":
+		n.Proto, n.UUID, n.Password, n.Transport = "tuic", o.UUID, o.Password, "quic"
+		n.CongestionControl, n.UDPRelayMode = o.CongestionControl, o.UDPRelayMode
+	case "wireguard":
+		n.Proto, n.Transport = "wireguard", "udp"
+		n.PrivateKey, n.PublicKey, n.PreSharedKey = o.PrivateKey, o.PeerPublicKey, o.PreSharedKey
+		n.LocalAddress, n.MTU, n.Reserved = o.LocalAddress, o.MTU, joinInts(o.Reserved)
+	case "socks":
+		n.Proto, n.Username, n.Password = "socks5", o.Username, o.Password
+	default:
+		return nil, errJSONType
+	}
+	if t := o.Transport; t != nil {
+		switch t.Type {
+		case "ws", "httpupgrade":
+			n.Transport, n.Path = t.Type, firstNonEmpty(t.Path, "/")
+			n.HostHeader = headerHost(t.Headers)
+			if h := firstHost(t.Host); h != "" {
+				n.HostHeader = h
+			}
+		case "http":
+			n.Transport, n.Path, n.HostHeader = "h2", firstNonEmpty(t.Path, "/"), firstHost(t.Host)
+		case "grpc":
+			n.Transport, n.ServiceName = "grpc", t.ServiceName
+		}
+	}
+	if t := o.TLS; t != nil && t.Enabled {
+		n.Security, n.SNI, n.AllowInsecure, n.ALPN = "tls", t.ServerName, t.Insecure, t.ALPN
+		n.Fingerprint = t.UTLS.Fingerprint
+		if t.Reality.Enabled {
+			n.Security, n.PublicKey, n.ShortID = "reality", t.Reality.PublicKey, t.Reality.ShortID
+		}
+	}
+	return uri.Parse(n.String())
+}
+
+type xrayOutbound struct {
+	Protocol string `json:"protocol"`
+	Tag      string `json:"tag"`
+	Settings struct {
+		Vnext []struct {
+			Address string `json:"address"`
+			Port    int    `json:"port"`
+			Users   []struct {
+				ID         string `json:"id"`
+				AlterID    int    `json:"alterId"`
+				Security   string `json:"security"`
+				Encryption string `json:"encryption"`
+				Flow       string `json:"flow"`
+			} `json:"users"`
+		} `json:"vnext"`
+		Servers []struct {
+			Address  string `json:"address"`
+			Port     int    `json:"port"`
+			Password string `json:"password"`
+			Method   string `json:"method"`
+			Users    []struct {
+				User string `json:"user"`
+				Pass string `json:"pass"`
+			} `json:"users"`
+		} `json:"servers"`
+	} `json:"settings"`
+	StreamSettings struct {
+		Network     string `json:"network"`
+		Security    string `json:"security"`
+		TLSSettings struct {
+			ServerName    string   `json:"serverName"`
+			AllowInsecure bool     `json:"allowInsecure"`
+			ALPN          []string `json:"alpn"`
+			Fingerprint   string   `json:"fingerprint"`
+		} `json:"tlsSettings"`
+		RealitySettings struct {
+			ServerName  string `json:"serverName"`
+			PublicKey   string `json:"publicKey"`
+			ShortID     string `json:"shortId"`
+			Fingerprint string `json:"fingerprint"`
+			SpiderX     string `json:"spiderX"`
+		} `json:"realitySettings"`
+		WSSettings struct {
+			Path    string            `json:"path"`
+			Host    string            `json:"host"`
+			Headers map[string]string `json:"headers"`
+		} `json:"wsSettings"`
+		HTTPUpgradeSettings struct {
+			Path string `json:"path"`
+			Host string `json:"host"`
+		} `json:"httpupgradeSettings"`
+		GRPCSettings struct {
+			ServiceName string `json:"serviceName"`
+			MultiMode   bool   `json:"multiMode"`
+		} `json:"grpcSettings"`
+		HTTPSettings struct {
+			Host []string `json:"host"`
+			Path string   `json:"path"`
+		} `json:"httpSettings"`
+		TCPSettings struct {
+			Header struct {
+				Type string `json:"type"`
+			} `json:"header"`
+		} `json:"tcpSettings"`
+	} `json:"streamSettings"`
+}
+
+func xrayNode(raw json.RawMessage) (*uri.Node, error) {
+	var o xrayOutbound
+	if err := json.Unmarshal(raw, &o); err != nil {
+		return nil, err
+	}
+	n := &uri.Node{Remark: o.Tag, Security: "none"}
+	s := o.Settings
+	switch o.Protocol {
+	case "vmess", "vless":
+		if len(s.Vnext) == 0 || len(s.Vnext[0].Users) == 0 {
+			return nil, errJSONType
+		}
+		v, u := s.Vnext[0], s.Vnext[0].Users[0]
+		n.Proto, n.Host, n.Port, n.UUID, n.Flow = o.Protocol, v.Address, v.Port, u.ID, u.Flow
+		if o.Protocol == "vmess" {
+			n.AlterID, n.Cipher = u.AlterID, firstNonEmpty(u.Security, "auto")
+		} else {
+			n.Cipher = firstNonEmpty(u.Encryption, "none")
+		}
+	case "trojan", "shadowsocks", "socks":
+		if len(s.Servers) == 0 {
+			return nil, errJSONType
+		}
+		sv := s.Servers[0]
+		n.Host, n.Port, n.Password = sv.Address, sv.Port, sv.Password
+		switch o.Protocol {
+		case "trojan":
+			n.Proto = "trojan"
+		case "shadowsocks":
+			n.Proto, n.Cipher = "ss", strings.ToLower(sv.Method)
+		case "socks":
+			n.Proto = "socks5"
+			if len(sv.Users) > 0 {
+				n.Username, n.Password = sv.Users[0].User, sv.Users[0].Pass
+			}
+		}
+	default:
+		return nil, errJSONType
+	}
+
+	ss := o.StreamSettings
+	n.Transport = firstNonEmpty(ss.Network, "tcp")
+	switch n.Transport {
+	case "raw":
+		n.Transport = "tcp"
+	case "ws":
+		n.Path, n.HostHeader = firstNonEmpty(ss.WSSettings.Path, "/"), firstNonEmpty(ss.WSSettings.Host, headerHost(ss.WSSettings.Headers))
+	case "httpupgrade":
+		n.Path, n.HostHeader = firstNonEmpty(ss.HTTPUpgradeSettings.Path, "/"), ss.HTTPUpgradeSettings.Host
+	case "grpc", "gun":
+		n.Transport, n.ServiceName = "grpc", ss.GRPCSettings.ServiceName
+		if ss.GRPCSettings.MultiMode {
+			n.Mode = "multi"
+		}
+	case "h2", "http":
+		n.Transport, n.Path = "h2", firstNonEmpty(ss.HTTPSettings.Path, "/")
+		if len(ss.HTTPSettings.Host) > 0 {
+			n.HostHeader = ss.HTTPSettings.Host[0]
+		}
+	}
+	if ht := ss.TCPSettings.Header.Type; ht != "" && ht != "none" {
+		n.HeaderType = ht
+	}
+	switch ss.Security {
+	case "tls":
+		t := ss.TLSSettings
+		n.Security, n.SNI, n.AllowInsecure, n.ALPN, n.Fingerprint = "tls", t.ServerName, t.AllowInsecure, t.ALPN, t.Fingerprint
+	case "reality":
+		r := ss.RealitySettings
+		n.Security, n.SNI, n.PublicKey, n.ShortID, n.Fingerprint, n.SpiderX = "reality", r.ServerName, r.PublicKey, r.ShortID, r.Fingerprint, r.SpiderX
+	default:
+		if n.Proto == "trojan" {
+			n.Security = "tls"
+		}
+	}
+	return uri.Parse(n.String())
+}
+
+func headerHost(h map[string]string) string {
+	for k, v := range h {
+		if strings.EqualFold(k, "host") {
+			return v
+		}
+	}
+	return ""
+}
+
+// firstHost accepts sing-box's host field, a string or a list of strings.
+func firstHost(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var l []string
+	if json.Unmarshal(raw, &l) == nil && len(l) > 0 {
+		return l[0]
+	}
+	return ""
+}
+
+func joinInts(v []int) string {
+	parts := make([]string, 0, len(v))
+	for _, x := range v {
+		parts = append(parts, strconv.Itoa(x))
+	}
+	return strings.Join(parts, ",")
+}
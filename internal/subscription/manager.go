@@ -13,9 +13,27 @@ import (
 
 var (
 	vmessRe   = regexp.MustCompile(`vmess://[A-Za-z0-9+/=]+`)
-	genericRe = regexp.MustCompile(`(vless://[^\s]+|trojan://[^\s]+|ss://[^\s]+|socks5://[^\s]+)`)
+	ssrRe     = regexp.MustCompile(`\bssr://[A-Za-z0-9_=-]+`)
+	// \b keeps "ss://" from matching inside "vmess://"
+	genericRe = regexp.MustCompile(`\b(?:vless|trojan|ss|socks5|hysteria2|hy2|tuic|wireguard|wg)://[^\s]+`)
 )
 
+// nodeSchemes are the share-link prefixes we ingest.
+var nodeSchemes = []string{
+	"vmess://", "vless://", "trojan://", "ss://", "ssr://", "socks5://",
+	"hysteria2://", "hy2://", "tuic://", "wireguard://", "wg://",
+}
+
+func hasNodeScheme(l string) bool {
+	l = strings.ToLower(l)
+	for _, p := range nodeSchemes {
+		if strings.HasPrefix(l, p) {
+			return true
+		}
+	}
+	return false
+}
+
 type SourceFetcher interface {
 	Fetch(ctx context.Context, url string) (string, error)
 }
@@ -54,8 +72,10 @@ func TryDecodeIfBase64Block(s string) string {
 	dec, err := base64.StdEncoding.DecodeString(candidate)
 	if err != nil { return s }
 	txt := string(dec)
-	if strings.Contains(txt, "vmess://") || strings.Contains(txt, "vless://") || strings.Contains(txt, "trojan://") || strings.Contains(txt, "ss://") {
-		return txt
+	for _, p := range nodeSchemes {
+		if strings.Contains(txt, p) {
+			return txt
+		}
 	}
 	return s
 }
@@ -64,15 +84,12 @@ func ExtractNodes(text string) []string {
 	txt := TryDecodeIfBase64Block(text)
 	nodes := []string{}
 	nodes = append(nodes, vmessRe.FindAllString(txt, -1)...)
+	nodes = append(nodes, ssrRe.FindAllString(txt, -1)...)
 	nodes = append(nodes, genericRe.FindAllString(txt, -1)...)
 	for _, line := range strings.Split(txt, "\n") {
 		l := strings.TrimSpace(line)
 		if l == "" { continue }
-		if strings.HasPrefix(strings.ToLower(l), "vmess://") ||
-			strings.HasPrefix(strings.ToLower(l), "vless://") ||
-			strings.HasPrefix(strings.ToLower(l), "trojan://") ||
-			strings.HasPrefix(strings.ToLower(l), "ss://") ||
-			strings.HasPrefix(strings.ToLower(l), "socks5://") {
+		if hasNodeScheme(l) {
 			nodes = append(nodes, l)
 		}
 	}
@@ -85,4 +102,27 @@ func ExtractNodes(text string) []string {
 		}
 	}
 	return out
-}
\ No newline at end of file
+}
+
+// Format names the layout of a fetched subscription body.
+type Format string
+
+const (
+	FormatURIList Format = "uri_list"
+	FormatClash   Format = "clash"
+	FormatSingBox Format = "sing-box"
+	FormatXray    Format = "xray"
+)
+
+// Decode auto-detects the body format and returns its nodes as share links.
+// skipped counts entries of a structured format that could not be mapped.
+func Decode(body string) (nodes []string, format Format, skipped int, err error) {
+	if LooksLikeJSONConfig(body) {
+		return DecodeJSONConfig(body)
+	}
+	if LooksLikeClash(body) {
+		nodes, skipped, err = DecodeClash(body)
+		return nodes, FormatClash, skipped, err
+	}
+	return ExtractNodes(body), FormatURIList, 0, nil
+}
@@ -15,13 +15,28 @@ type Manager interface {
 	Quarantine(id string) error
 	Delete(id string) error
 	Rollback(id string) error
+	// Subscription returns the current node set of a named profile, or
+	// ErrUnknownProfile.
+	Subscription(profile string) (*Subscription, error)
+	Health() Health
+}
+
+// Health is the /healthz body. The endpoint answers 200 while the process
+// is up; Degraded flags that outputs come from a fallback policy.
+type Health struct {
+	OK       bool   `json:"ok"`
+	Output   string `json:"output"` // healthy, degraded_keep_last, degraded_top_n, degraded_all, failed, pending
+	Degraded bool   `json:"degraded"`
 }
 
 type Server struct {
 	Mgr          Manager
 	MetricsPath  string
 	HealthzPath  string
+	// SubToken, when set, must be passed as ?token= to /sub/ endpoints.
+	SubToken     string
 	reqInFlight  atomic.Int64
+	mods         modTracker
 }
 
 func New(mgr Manager, metricsPath, healthzPath string) *Server {
@@ -31,10 +46,14 @@ func New(mgr Manager, metricsPath, healthzPath string) *Server {
 func (s *Server) routes() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc(s.HealthzPath, func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(200); _, _ = w.Write([]byte("ok"))
+		h := s.Mgr.Health()
+		h.OK = true
+		sendJSON(w, 200, h)
 	})
 	mux.Handle(s.MetricsPath, promhttp.Handler())
 
+	mux.HandleFunc("/sub/", s.wrap(s.handleSub))
+
 	mux.HandleFunc("/api/v1/configs", s.wrap(func(w http.ResponseWriter, r *http.Request) {
 		sendJSON(w, 200, s.Mgr.ListConfigs())
 	}))
@@ -73,6 +92,9 @@ func (s *Server) wrap(h func(w http.ResponseWriter, r *http.Request)) http.Handl
 	}
 }
 
+// Handler exposes the routes for embedding and tests.
+func (s *Server) Handler() http.Handler { return s.routes() }
+
 func (s *Server) Start(addr string) error {
 	return http.ListenAndServe(addr, s.routes())
 }
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yasi-python/go/pkg/export"
+)
+
+// ErrUnknownProfile is returned by Manager.Subscription for profiles that
+// are not configured.
+var ErrUnknownProfile = errors.New("unknown_profile")
+
+// ErrNoOutput is returned by Manager.Subscription when the degraded-output
+// policy leaves nothing to serve.
+var ErrNoOutput = errors.New("no_output")
+
+// Subscription is the node set behind one /sub/{profile} URL plus the
+// settings needed to render it.
+type Subscription struct {
+	Entries []export.Entry
+	Clash   export.ClashOptions
+	Client  export.ClientOptions
+	// UpdateIntervalHours is sent as profile-update-interval when > 0.
+	UpdateIntervalHours int
+	// Userinfo is sent verbatim as subscription-userinfo when set.
+	Userinfo string
+}
+
+// formatAliases maps ?format= (or ?target=) values onto export formats.
+var formatAliases = map[string]string{
+	"base64": export.FormatBase64, "b64": export.FormatBase64, "v2ray": export.FormatBase64,
+	"plain": export.FormatPlain, "txt": export.FormatPlain, "raw": export.FormatPlain,
+	"clash": export.FormatClash, "clashmeta": export.FormatClash, "clash.meta": export.FormatClash, "mihomo": export.FormatClash,
+	"singbox": export.FormatSingBox, "sing-box": export.FormatSingBox,
+	"xray": export.FormatXray,
+}
+
+var contentTypes = map[string]string{
+	export.FormatClash:   "text/yaml; charset=utf-8",
+	export.FormatSingBox: "application/json",
+	export.FormatXray:    "application/json",
+}
+
+// sniffFormat picks a format from well-known client User-Agents; anything
+// unrecognised gets base64, which every v2ray-style client understands.
+func sniffFormat(ua string) string {
+	ua = strings.ToLower(ua)
+	switch {
+	case strings.Contains(ua, "clash"), strings.Contains(ua, "mihomo"), strings.Contains(ua, "stash"):
+		return export.FormatClash
+	case strings.Contains(ua, "sing-box"), strings.HasPrefix(ua, "sfa"), strings.HasPrefix(ua, "sfi"), strings.HasPrefix(ua, "sfm"):
+		return export.FormatSingBox
+	}
+	return export.FormatBase64
+}
+
+// modTracker remembers when each rendered body last changed, so
+// Last-Modified moves only when the content does.
+type modTracker struct {
+	mu   sync.Mutex
+	seen map[string]modEntry
+}
+
+type modEntry struct {
+	etag string
+	at   time.Time
+}
+
+func (t *modTracker) touch(key, etag string, now time.Time) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen == nil {
+		t.seen = map[string]modEntry{}
+	}
+	if e, ok := t.seen[key]; ok && e.etag == etag {
+		return e.at
+	}
+	t.seen[key] = modEntry{etag: etag, at: now}
+	return now
+}
+
+func (s *Server) handleSub(w http.ResponseWriter, r *http.Request) {
+	profile := strings.Trim(strings.TrimPrefix(r.URL.Path, "/sub/"), "/")
+	if profile == "" || strings.Contains(profile, "/") {
+		sendJSON(w, 404, errMsg("unknown profile"))
+		return
+	}
+	q := r.URL.Query()
+	if s.SubToken != "" && subtle.ConstantTimeCompare([]byte(q.Get("token")), []byte(s.SubToken)) != 1 {
+		sendJSON(w, 401, errMsg("bad token"))
+		return
+	}
+	format := sniffFormat(r.UserAgent())
+	if f := strings.ToLower(firstNonEmpty(q.Get("format"), q.Get("target"))); f != "" {
+		var ok bool
+		if format, ok = formatAliases[f]; !ok {
+			sendJSON(w, 400, errMsg("unknown format"))
+			return
+		}
+	}
+	sub, err := s.Mgr.Subscription(profile)
+	if errors.Is(err, ErrUnknownProfile) {
+		sendJSON(w, 404, errMsg("unknown profile"))
+		return
+	}
+	if errors.Is(err, ErrNoOutput) {
+		sendJSON(w, 503, errMsg("no healthy nodes"))
+		return
+	}
+	if err != nil {
+		sendJSON(w, 500, errMsg(err.Error()))
+		return
+	}
+	body, err := export.Render(format, sub.Entries, sub.Clash, sub.Client)
+	if err != nil {
+		sendJSON(w, 500, errMsg(err.Error()))
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:12]) + `"`
+	// second precision: Last-Modified cannot carry more
+	mod := s.mods.touch(profile+"|"+format, etag, time.Now().Truncate(time.Second))
+
+	h := w.Header()
+	ctype, ok := contentTypes[format]
+	if !ok {
+		ctype = "text/plain; charset=utf-8"
+	}
+	h.Set("Content-Type", ctype)
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(profile))
+	if sub.UpdateIntervalHours > 0 {
+		h.Set("profile-update-interval", strconv.Itoa(sub.UpdateIntervalHours))
+	}
+	if sub.Userinfo != "" {
+		h.Set("subscription-userinfo", sub.Userinfo)
+	}
+	// ServeContent answers If-None-Match / If-Modified-Since with 304
+	http.ServeContent(w, r, "", mod, bytes.NewReader(body))
+}
+
+func firstNonEmpty(vs ...string) string {
+	for _, v := range vs {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -1,36 +1,116 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type ServiceCfg struct {
-	HTTPListen                  string `yaml:"http_listen"`
-	MetricsPath                 string `yaml:"metrics_path"`
-	HealthzPath                 string `yaml:"healthz_path"`
-	DryRun                      bool   `yaml:"dry_run"`
-	LogLevel                    string `yaml:"log_level"`
-	DataDir                     string `yaml:"data_dir"`
-	SnapshotsDir                string `yaml:"snapshots_dir"`
-	SnapshotRetentionDays       int    `yaml:"snapshot_retention_days"`
-	MaxDeletionsPerDay          int    `yaml:"max_deletions_per_day"`
-	Concurrency                 int    `yaml:"concurrency"`
-	RateLimitPerTargetPerMinute int    `yaml:"rate_limit_per_target_per_minute"`
-	ReprobeScheduleSeconds      int    `yaml:"reprobe_schedule_seconds"`
+	HTTPListen                  string   `yaml:"http_listen"`
+	MetricsPath                 string   `yaml:"metrics_path"`
+	HealthzPath                 string   `yaml:"healthz_path"`
+	DryRun                      bool     `yaml:"dry_run"`
+	LogLevel                    string   `yaml:"log_level"`
+	DataDir                     string   `yaml:"data_dir"`
+	SnapshotsDir                string   `yaml:"snapshots_dir"`
+	SnapshotRetentionDays       int      `yaml:"snapshot_retention_days"`
+	MaxDeletionsPerDay          int      `yaml:"max_deletions_per_day"`
+	Concurrency                 int      `yaml:"concurrency"`
+	RateLimitPerTargetPerMinute int      `yaml:"rate_limit_per_target_per_minute"`
+	RateLimitBurst              int      `yaml:"rate_limit_burst"`  // default 1: evenly spaced
+	RateLimitScopes             []string `yaml:"rate_limit_scopes"` // ip (default), net24, host
+	ReprobeScheduleSeconds      int      `yaml:"reprobe_schedule_seconds"`
 }
 
 type SubscriptionsCfg struct {
-	Sources              []string `yaml:"sources"`
+	Sources              []Source `yaml:"sources"`
 	FetchIntervalSeconds int      `yaml:"fetch_interval_seconds"`
 	PerSourceLimit       int      `yaml:"per_source_limit"`
 	MergedLimit          int      `yaml:"merged_limit"`
 	Outputs              struct {
-		PlainPath  string `yaml:"plain_path"`
-		Base64Path string `yaml:"base64_path"`
+		PlainPath   string    `yaml:"plain_path"`
+		Base64Path  string    `yaml:"base64_path"`
+		ClashPath   string    `yaml:"clash_path"`
+		Clash       ClashCfg  `yaml:"clash"`
+		SingBoxPath string    `yaml:"singbox_path"`
+		XrayPath    string    `yaml:"xray_path"`
+		Client      ClientCfg `yaml:"client"`
+		// RemarkTemplate rewrites exported remarks, e.g.
+		// "{{flag}} {{country}} {{proto}}-{{net}} {{latency_ms}}ms"; empty keeps upstream remarks.
+		RemarkTemplate string `yaml:"remark_template"`
 	} `yaml:"outputs"`
+	// Profiles are extra named outputs, each a filtered view of the healthy set.
+	Profiles []ProfileCfg `yaml:"profiles"`
+	Degraded DegradedCfg  `yaml:"degraded"`
+}
+
+// DegradedCfg decides what is published when fewer than MinHealthy nodes
+// pass the health check.
+type DegradedCfg struct {
+	Policy     string `yaml:"policy"`      // keep_last (default) | top_n | all | fail
+	TopN       int    `yaml:"top_n"`       // for top_n; default 50
+	MinHealthy int    `yaml:"min_healthy"` // default 1
+}
+
+// Source is a subscription URL; in YAML either a plain string or
+// {url, tags}. Tags are attached to every node the source provides.
+type Source struct {
+	URL  string   `yaml:"url"`
+	Tags []string `yaml:"tags"`
+}
+
+func (s *Source) UnmarshalYAML(v *yaml.Node) error {
+	if v.Kind == yaml.ScalarNode {
+		s.URL = v.Value
+		return nil
+	}
+	type plain Source
+	return v.Decode((*plain)(s))
+}
+
+// ProfileCfg is a named output profile, served at /sub/{name} and written
+// to the paths in Outputs (format -> path; formats: plain, base64, clash,
+// singbox, xray).
+type ProfileCfg struct {
+	Name    string            `yaml:"name"`
+	Filter  FilterCfg         `yaml:"filter"`
+	Sort    string            `yaml:"sort"` // latency (default) | success_rate | name | throughput
+	Limit   int               `yaml:"limit"`
+	Remark  string            `yaml:"remark"` // remark template, see Outputs.RemarkTemplate
+	Outputs map[string]string `yaml:"outputs"`
+}
+
+// FilterCfg selects nodes for a profile; empty fields match everything.
+type FilterCfg struct {
+	Protocols      []string `yaml:"protocols"`  // vmess, vless, trojan, ss, ssr, hysteria2, tuic, wireguard, socks5
+	Transports     []string `yaml:"transports"` // tcp, ws, grpc, h2, httpupgrade, quic
+	TLSOnly        bool     `yaml:"tls_only"`
+	UDPOnly        bool     `yaml:"udp_only"` // UDP-based protocols: hysteria2, tuic, wireguard
+	MaxLatencyMS   float64  `yaml:"max_latency_ms"`
+	MinSuccessRate float64  `yaml:"min_success_rate"`  // 0..1
+	Countries      []string `yaml:"countries"`         // ISO codes, taken from the remark's flag
+	MinOrigins     int      `yaml:"min_origins"`       // origins agreeing on the last probe
+	Tags           []string `yaml:"tags"`              // source tags, any of
+	MinBytesPerSec float64  `yaml:"min_bytes_per_sec"` // measured throughput, e.g. 1250000 = 10 Mbit/s
+}
+
+// ClashCfg shapes the generated Clash.Meta profile.
+type ClashCfg struct {
+	TestURL         string   `yaml:"test_url"`
+	IntervalSeconds int      `yaml:"interval_seconds"`
+	Rules           []string `yaml:"rules"`      // {{group}} expands to the selector group
+	RulesFile       string   `yaml:"rules_file"` // one rule per line; overrides rules
+}
+
+// ClientCfg shapes the generated sing-box and Xray client configs.
+type ClientCfg struct {
+	TestURL         string `yaml:"test_url"`
+	IntervalSeconds int    `yaml:"interval_seconds"`
+	ListenPort      int    `yaml:"listen_port"` // local socks/mixed inbound; Xray adds http on +1
 }
 
 type ProbeCfg struct {
@@ -40,6 +120,33 @@ type ProbeCfg struct {
 	BackoffMaxMS            int      `yaml:"backoff_max_ms"`
 	HTTPProbePaths          []string `yaml:"http_probe_paths"`
 	PreferHTTPIfWSOrPath    bool     `yaml:"prefer_http_if_ws_or_path"`
+	// HandshakeURL is fetched through ss/trojan/vless/vmess nodes with a
+	// real protocol handshake; empty disables that stage.
+	HandshakeURL string `yaml:"handshake_url"`
+	// Fetch is an end-to-end content check run through the tunnel after a
+	// successful handshake; empty URL disables it.
+	Fetch FetchCfg `yaml:"fetch"`
+	// Throughput measures bandwidth of the best healthy nodes on its own,
+	// slower schedule; empty URL disables it.
+	Throughput ThroughputCfg `yaml:"throughput"`
+}
+
+// ThroughputCfg configures the bandwidth stage.
+type ThroughputCfg struct {
+	URL             string `yaml:"url"`              // download through the node
+	MaxBytes        int64  `yaml:"max_bytes"`        // default 10 MiB
+	MaxSeconds      int    `yaml:"max_seconds"`      // download window; default 10
+	ScheduleSeconds int    `yaml:"schedule_seconds"` // default 21600 (6h)
+	TopN            int    `yaml:"top_n"`            // fastest healthy nodes measured per round; default 20
+}
+
+// FetchCfg configures the through-the-proxy content check.
+type FetchCfg struct {
+	URL          string `yaml:"url"`
+	ExpectStatus int    `yaml:"expect_status"`  // 0: any 2xx
+	ExpectBody   string `yaml:"expect_body"`    // substring
+	ExpectSHA256 string `yaml:"expect_sha256"`  // hex digest of the body
+	MaxBodyBytes int64  `yaml:"max_body_bytes"` // default 65536
 }
 
 type Origin struct {
@@ -64,7 +171,15 @@ type SecurityCfg struct {
 }
 
 type APICfg struct {
-	RateLimitPerMinute int `yaml:"rate_limit_per_minute"`
+	RateLimitPerMinute int             `yaml:"rate_limit_per_minute"`
+	Subscription       SubscriptionAPI `yaml:"subscription"`
+}
+
+// SubscriptionAPI controls the /sub/{profile} endpoints.
+type SubscriptionAPI struct {
+	Token               string `yaml:"token"`                 // required as ?token= when set
+	UpdateIntervalHours int    `yaml:"update_interval_hours"` // profile-update-interval; default: fetch interval
+	Userinfo            string `yaml:"userinfo"`              // subscription-userinfo header, sent verbatim
 }
 
 type Config struct {
@@ -89,6 +204,25 @@ func Load(path string) (*Config, error) {
 	if c.Service.Concurrency <= 0 {
 		c.Service.Concurrency = 100
 	}
+	switch c.Subscriptions.Degraded.Policy {
+	case "", "keep_last", "top_n", "all", "fail":
+	default:
+		return nil, fmt.Errorf("invalid_degraded_policy: %q", c.Subscriptions.Degraded.Policy)
+	}
+	seen := map[string]bool{"default": true}
+	for _, p := range c.Subscriptions.Profiles {
+		if p.Name == "" || strings.Contains(p.Name, "/") || seen[p.Name] {
+			return nil, fmt.Errorf("invalid_profile_name: %q", p.Name)
+		}
+		seen[p.Name] = true
+		for f := range p.Outputs {
+			switch f {
+			case "plain", "base64", "clash", "singbox", "xray":
+			default:
+				return nil, fmt.Errorf("invalid_profile_format: %s: %q", p.Name, f)
+			}
+		}
+	}
 	return &c, nil
 }
 
@@ -100,4 +234,4 @@ func (c *Config) QuarantineRechecksDurations() []time.Duration {
 		}
 	}
 	return out
-}
\ No newline at end of file
+}
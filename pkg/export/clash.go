@@ -0,0 +1,278 @@
+package export
+
+import (
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/yasi-python/go/pkg/uri"
+)
+
+// ClashOptions configures the generated Clash.Meta profile.
+type ClashOptions struct {
+	TestURL         string
+	IntervalSeconds int
+	// Rules are emitted verbatim; {{group}} expands to the selector name.
+	Rules []string
+}
+
+const (
+	clashSelectGroup   = "PROXY"
+	clashAutoGroup     = "auto"
+	clashFallbackGroup = "fallback"
+)
+
+var defaultClashRules = []string{"MATCH,{{group}}"}
+
+type clashProfile struct {
+	Mode        string       `yaml:"mode"`
+	Proxies     []clashProxy `yaml:"proxies"`
+	ProxyGroups []clashGroup `yaml:"proxy-groups"`
+	Rules       []string     `yaml:"rules"`
+}
+
+type clashGroup struct {
+	Name      string   `yaml:"name"`
+	Type      string   `yaml:"type"`
+	Proxies   []string `yaml:"proxies"`
+	URL       string   `yaml:"url,omitempty"`
+	Interval  int      `yaml:"interval,omitempty"`
+	Tolerance int      `yaml:"tolerance,omitempty"`
+}
+
+type clashProxy struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"`
+	Server   string `yaml:"server"`
+	Port     int    `yaml:"port"`
+	Ports    string `yaml:"ports,omitempty"`
+	UUID     string `yaml:"uuid,omitempty"`
+	AlterID  *int   `yaml:"alterId,omitempty"`
+	Cipher   string `yaml:"cipher,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Flow     string `yaml:"flow,omitempty"`
+	UDP      bool   `yaml:"udp,omitempty"`
+
+	TLS               bool              `yaml:"tls,omitempty"`
+	ServerName        string            `yaml:"servername,omitempty"`
+	SNI               string            `yaml:"sni,omitempty"`
+	ALPN              []string          `yaml:"alpn,omitempty"`
+	SkipCertVerify    bool              `yaml:"skip-cert-verify,omitempty"`
+	ClientFingerprint string            `yaml:"client-fingerprint,omitempty"`
+	RealityOpts       map[string]string `yaml:"reality-opts,omitempty"`
+
+	Network  string         `yaml:"network,omitempty"`
+	WSOpts   map[string]any `yaml:"ws-opts,omitempty"`
+	GRPCOpts map[string]any `yaml:"grpc-opts,omitempty"`
+	H2Opts   map[string]any `yaml:"h2-opts,omitempty"`
+
+	Plugin     string         `yaml:"plugin,omitempty"`
+	PluginOpts map[string]any `yaml:"plugin-opts,omitempty"`
+
+	Protocol      string `yaml:"protocol,omitempty"`
+	ProtocolParam string `yaml:"protocol-param,omitempty"`
+	Obfs          string `yaml:"obfs,omitempty"`
+	ObfsParam     string `yaml:"obfs-param,omitempty"`
+	ObfsPassword  string `yaml:"obfs-password,omitempty"`
+
+	CongestionController string `yaml:"congestion-controller,omitempty"`
+	UDPRelayMode         string `yaml:"udp-relay-mode,omitempty"`
+
+	PrivateKey   string `yaml:"private-key,omitempty"`
+	PublicKey    string `yaml:"public-key,omitempty"`
+	PreSharedKey string `yaml:"pre-shared-key,omitempty"`
+	IP           string `yaml:"ip,omitempty"`
+	IPv6         string `yaml:"ipv6,omitempty"`
+	MTU          int    `yaml:"mtu,omitempty"`
+	Reserved     []int  `yaml:"reserved,omitempty"`
+}
+
+// Clash renders entries as a Clash.Meta (mihomo) profile with a selector,
+// a url-test and a fallback group. Entries are expected to be sorted
+// already; group members follow the same order.
+func Clash(es []Entry, opt ClashOptions) ([]byte, error) {
+	names := uniqueNames(es)
+	p := clashProfile{Mode: "rule", Proxies: []clashProxy{}}
+	kept := []string{}
+	for i, e := range es {
+		cp, ok := toClash(e.Node)
+		if !ok {
+			continue
+		}
+		cp.Name = names[i]
+		p.Proxies = append(p.Proxies, cp)
+		kept = append(kept, names[i])
+	}
+	if len(kept) == 0 {
+		// clients reject empty groups
+		kept = []string{"DIRECT"}
+	}
+	testURL := opt.TestURL
+	if testURL == "" {
+		testURL = "https://www.gstatic.com/generate_204"
+	}
+	interval := opt.IntervalSeconds
+	if interval <= 0 {
+		interval = 300
+	}
+	p.ProxyGroups = []clashGroup{
+		{Name: clashSelectGroup, Type: "select", Proxies: append([]string{clashAutoGroup, clashFallbackGroup}, kept...)},
+		{Name: clashAutoGroup, Type: "url-test", Proxies: kept, URL: testURL, Interval: interval, Tolerance: 50},
+		{Name: clashFallbackGroup, Type: "fallback", Proxies: kept, URL: testURL, Interval: interval},
+	}
+	rules := opt.Rules
+	if len(rules) == 0 {
+		rules = defaultClashRules
+	}
+	for _, r := range rules {
+		p.Rules = append(p.Rules, strings.ReplaceAll(r, "{{group}}", clashSelectGroup))
+	}
+	return yaml.Marshal(p)
+}
+
+// toClash maps a node onto mihomo's proxy schema; false means the client
+// has no equivalent (e.g. ss with an unknown plugin).
+func toClash(n *uri.Node) (clashProxy, bool) {
+	c := clashProxy{Server: n.Host, Port: n.Port, UDP: true}
+	tlsFields := func() {
+		c.SkipCertVerify = n.AllowInsecure
+		c.ALPN = n.ALPN
+		c.ClientFingerprint = n.Fingerprint
+	}
+	switch n.Proto {
+	case "ss":
+		c.Type, c.Cipher, c.Password = "ss", n.Cipher, n.Password
+		switch n.Plugin {
+		case "":
+		case "obfs-local":
+			c.Plugin = "obfs"
+			c.PluginOpts = map[string]any{"mode": n.PluginOpt("obfs")}
+			if h := n.PluginOpt("obfs-host"); h != "" {
+				c.PluginOpts["host"] = h
+			}
+		case "v2ray-plugin":
+			c.Plugin = "v2ray-plugin"
+			c.PluginOpts = map[string]any{"mode": or(n.PluginOpt("mode"), "websocket")}
+			if strings.Contains(";"+n.PluginOpts+";", ";tls;") {
+				c.PluginOpts["tls"] = true
+			}
+			if h := n.PluginOpt("host"); h != "" {
+				c.PluginOpts["host"] = h
+			}
+			if p := n.PluginOpt("path"); p != "" {
+				c.PluginOpts["path"] = p
+			}
+		default:
+			return c, false
+		}
+	case "ssr":
+		c.Type, c.Cipher, c.Password = "ssr", n.Cipher, n.Password
+		c.Protocol, c.ProtocolParam, c.Obfs, c.ObfsParam = n.SSRProtocol, n.SSRProtocolParam, n.Obfs, n.ObfsParam
+	case "vmess":
+		aid := n.AlterID
+		c.Type, c.UUID, c.AlterID, c.Cipher = "vmess", n.UUID, &aid, or(n.Cipher, "auto")
+		if !streamToClash(n, &c) {
+			return c, false
+		}
+		if n.TLS() {
+			c.TLS, c.ServerName = true, n.ServerName()
+			tlsFields()
+		}
+	case "vless":
+		c.Type, c.UUID, c.Flow = "vless", n.UUID, n.Flow
+		if !streamToClash(n, &c) {
+			return c, false
+		}
+		if n.TLS() {
+			c.TLS, c.ServerName = true, n.ServerName()
+			tlsFields()
+		}
+		if n.Security == "reality" {
+			c.RealityOpts = map[string]string{"public-key": n.PublicKey, "short-id": n.ShortID}
+		}
+	case "trojan":
+		c.Type, c.Password, c.SNI = "trojan", n.Password, n.ServerName()
+		if !streamToClash(n, &c) {
+			return c, false
+		}
+		tlsFields()
+		if n.Security == "reality" {
+			c.RealityOpts = map[string]string{"public-key": n.PublicKey, "short-id": n.ShortID}
+		}
+	case "hysteria2":
+		c.Type, c.Password, c.SNI, c.Ports = "hysteria2", n.Password, n.SNI, n.Ports
+		c.Obfs, c.ObfsPassword = n.Obfs, n.ObfsParam
+		tlsFields()
+	case "tuic":
+		c.Type, c.UUID, c.Password, c.SNI = "tuic", n.UUID, n.Password, n.SNI
+		c.CongestionController, c.UDPRelayMode = n.CongestionControl, n.UDPRelayMode
+		tlsFields()
+	case "wireguard":
+		c.Type, c.PrivateKey, c.PublicKey, c.PreSharedKey, c.MTU = "wireguard", n.PrivateKey, n.PublicKey, n.PreSharedKey, n.MTU
+		for _, a := range n.LocalAddress {
+			ip, _, _ := strings.Cut(a, "/")
+			if strings.Contains(ip, ":") {
+				c.IPv6 = ip
+			} else {
+				c.IP = ip
+			}
+		}
+		c.Reserved = reservedBytes(n.Reserved)
+	case "socks5":
+		c.Type, c.Username, c.Password = "socks5", n.Username, n.Password
+	default:
+		return c, false
+	}
+	return c, true
+}
+
+// reservedBytes parses the wireguard "1,2,3" reserved field.
+func reservedBytes(s string) []int {
+	var out []int
+	for _, r := range strings.Split(s, ",") {
+		if v, err := strconv.Atoi(strings.TrimSpace(r)); err == nil {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func streamToClash(n *uri.Node, c *clashProxy) bool {
+	switch n.Transport {
+	case "", "tcp":
+	case "ws":
+		c.Network = "ws"
+		c.WSOpts = map[string]any{"path": or(n.Path, "/")}
+		if n.HostHeader != "" {
+			c.WSOpts["headers"] = map[string]string{"Host": n.HostHeader}
+		}
+	case "grpc":
+		c.Network = "grpc"
+		c.GRPCOpts = map[string]any{"grpc-service-name": n.ServiceName}
+	case "h2":
+		c.Network = "h2"
+		c.H2Opts = map[string]any{"path": or(n.Path, "/")}
+		if n.HostHeader != "" {
+			c.H2Opts["host"] = []string{n.HostHeader}
+		}
+	case "httpupgrade":
+		// mihomo models httpupgrade as ws with v2ray-http-upgrade
+		c.Network = "ws"
+		c.WSOpts = map[string]any{"path": or(n.Path, "/"), "v2ray-http-upgrade": true}
+		if n.HostHeader != "" {
+			c.WSOpts["headers"] = map[string]string{"Host": n.HostHeader}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func or(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
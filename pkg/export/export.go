@@ -0,0 +1,94 @@
+package export
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yasi-python/go/pkg/uri"
+)
+
+// Entry is a healthy node together with the measurements exporters sort
+// and label by.
+type Entry struct {
+	ID          string // storage key of the record, not necessarily Node.ID()
+	Node        *uri.Node
+	LatencyMS   float64
+	SuccessRate float64
+	Country     string // ISO 3166 alpha-2, see Country
+	OriginsOK   int
+	Tags        []string
+	BytesPerSec float64 // last throughput measurement, 0 when unmeasured
+}
+
+// SortByLatency orders entries fastest first; entries without a latency
+// sample go last, ties are broken by success rate.
+func SortByLatency(es []Entry) {
+	sort.SliceStable(es, func(i, j int) bool { return latencyLess(es[i], es[j]) })
+}
+
+func latencyLess(x, y Entry) bool {
+	a, b := x.LatencyMS, y.LatencyMS
+	if (a == 0) != (b == 0) {
+		return b == 0
+	}
+	if a != b {
+		return a < b
+	}
+	return x.SuccessRate > y.SuccessRate
+}
+
+// Country returns the ISO country code of the first flag emoji in a
+// remark ("🇩🇪 Frankfurt" -> "DE"), or "" when there is none.
+func Country(remark string) string {
+	var prev rune
+	for _, r := range remark {
+		if r >= 0x1F1E6 && r <= 0x1F1FF {
+			if prev != 0 {
+				return string([]rune{'A' + prev - 0x1F1E6, 'A' + r - 0x1F1E6})
+			}
+			prev = r
+			continue
+		}
+		prev = 0
+	}
+	return ""
+}
+
+// uniqueNames returns one display name per entry, suffixing repeats, since
+// clients key proxies by name.
+func uniqueNames(es []Entry) []string {
+	seen := map[string]int{}
+	out := make([]string, len(es))
+	for i, e := range es {
+		name := e.Node.Remark
+		if name == "" {
+			name = fmt.Sprintf("%s-%s:%d", e.Node.Proto, e.Node.Host, e.Node.Port)
+		}
+		seen[name]++
+		if c := seen[name]; c > 1 {
+			name = fmt.Sprintf("%s #%d", name, c)
+		}
+		out[i] = name
+	}
+	return out
+}
+
+// Plain renders the share links one per line.
+func Plain(es []Entry) []byte {
+	return []byte(strings.Join(raws(es), "\n") + "\n")
+}
+
+// Base64 renders the share links as a classic base64 subscription body.
+func Base64(es []Entry) []byte {
+	return []byte(base64.StdEncoding.EncodeToString([]byte(strings.Join(raws(es), "\n"))))
+}
+
+func raws(es []Entry) []string {
+	out := make([]string, 0, len(es))
+	for _, e := range es {
+		out = append(out, e.Node.Raw)
+	}
+	return out
+}
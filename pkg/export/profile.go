@@ -0,0 +1,139 @@
+package export
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Output formats understood by Render.
+const (
+	FormatPlain   = "plain"
+	FormatBase64  = "base64"
+	FormatClash   = "clash"
+	FormatSingBox = "singbox"
+	FormatXray    = "xray"
+)
+
+// ErrUnknownFormat is returned by Render for unsupported formats.
+var ErrUnknownFormat = errors.New("unknown_format")
+
+// Render encodes es in one of the Format* encodings.
+func Render(format string, es []Entry, clash ClashOptions, client ClientOptions) ([]byte, error) {
+	switch format {
+	case FormatPlain:
+		return Plain(es), nil
+	case FormatBase64:
+		return Base64(es), nil
+	case FormatClash:
+		return Clash(es, clash)
+	case FormatSingBox:
+		return SingBox(es, client)
+	case FormatXray:
+		return Xray(es, client)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Filter selects the entries of an output profile. Zero fields match
+// everything; list fields match any of their values.
+type Filter struct {
+	Protocols      []string
+	Transports     []string
+	TLSOnly        bool
+	UDPOnly        bool // outer transport is UDP (uri.Node.UDP)
+	MaxLatencyMS   float64
+	MinSuccessRate float64
+	Countries      []string
+	MinOrigins     int // origins that agreed on the last successful probe
+	Tags           []string
+	MinBytesPerSec float64 // measured throughput; unmeasured entries fail it
+}
+
+// Match reports whether e passes every criterion of f.
+func (f Filter) Match(e Entry) bool {
+	n := e.Node
+	switch {
+	case len(f.Protocols) > 0 && !containsFold(f.Protocols, n.Proto),
+		len(f.Transports) > 0 && !containsFold(f.Transports, n.Transport),
+		f.TLSOnly && !n.TLS(),
+		f.UDPOnly && !n.UDP(),
+		f.MaxLatencyMS > 0 && (e.LatencyMS == 0 || e.LatencyMS > f.MaxLatencyMS),
+		f.MinSuccessRate > 0 && e.SuccessRate < f.MinSuccessRate,
+		len(f.Countries) > 0 && !containsFold(f.Countries, e.Country),
+		f.MinOrigins > 0 && e.OriginsOK < f.MinOrigins,
+		f.MinBytesPerSec > 0 && e.BytesPerSec < f.MinBytesPerSec:
+		return false
+	}
+	if len(f.Tags) > 0 {
+		for _, t := range e.Tags {
+			if containsFold(f.Tags, t) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Sort orders accepted by Select.
+const (
+	SortLatency     = "latency"
+	SortSuccessRate = "success_rate"
+	SortName        = "name"
+	SortThroughput  = "throughput"
+)
+
+// Select filters es, orders the result by sortBy (latency when empty) and
+// truncates it to limit when limit > 0. es is not modified.
+func Select(es []Entry, f Filter, sortBy string, limit int) []Entry {
+	out := []Entry{}
+	for _, e := range es {
+		if f.Match(e) {
+			out = append(out, e)
+		}
+	}
+	switch sortBy {
+	case SortSuccessRate:
+		sort.SliceStable(out, func(i, j int) bool {
+			if out[i].SuccessRate != out[j].SuccessRate {
+				return out[i].SuccessRate > out[j].SuccessRate
+			}
+			return latencyLess(out[i], out[j])
+		})
+	case SortThroughput:
+		sort.SliceStable(out, func(i, j int) bool {
+			if out[i].BytesPerSec != out[j].BytesPerSec {
+				return out[i].BytesPerSec > out[j].BytesPerSec
+			}
+			return latencyLess(out[i], out[j])
+		})
+	case SortName:
+		names := uniqueNames(out)
+		idx := make([]int, len(out))
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.SliceStable(idx, func(i, j int) bool { return names[idx[i]] < names[idx[j]] })
+		sorted := make([]Entry, len(out))
+		for i, k := range idx {
+			sorted[i] = out[k]
+		}
+		out = sorted
+	default:
+		SortByLatency(out)
+	}
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func containsFold(list []string, v string) bool {
+	for _, x := range list {
+		if strings.EqualFold(x, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package export
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Rename returns copies of es whose remarks are rendered from tmpl, with
+// the share link re-encoded to carry the new remark (vmess "ps", ssr
+// "remarks", the URI fragment otherwise). Placeholders:
+//
+//	{{flag}} {{country}} {{proto}} {{net}} {{security}} {{host}} {{port}}
+//	{{latency_ms}} {{success_pct}} {{origins}} {{mbps}} {{remark}} {{index}}
+//
+// Unknown placeholders are kept verbatim; repeated results get " #N"
+// suffixes. An empty tmpl returns es unchanged.
+func Rename(es []Entry, tmpl string) []Entry {
+	if tmpl == "" {
+		return es
+	}
+	out := make([]Entry, len(es))
+	for i, e := range es {
+		n := *e.Node
+		n.Remark = renderRemark(tmpl, e, i+1)
+		e.Node = &n
+		out[i] = e
+	}
+	for i, name := range uniqueNames(out) {
+		out[i].Node.Remark = name
+		out[i].Node.Raw = out[i].Node.String()
+	}
+	return out
+}
+
+func renderRemark(tmpl string, e Entry, index int) string {
+	n := e.Node
+	latency := "?"
+	if e.LatencyMS > 0 {
+		latency = strconv.Itoa(int(e.LatencyMS + 0.5))
+	}
+	mbps := "?"
+	if e.BytesPerSec > 0 {
+		mbps = strconv.FormatFloat(e.BytesPerSec*8/1e6, 'f', 1, 64)
+	}
+	r := strings.NewReplacer(
+		"{{flag}}", Flag(e.Country),
+		"{{country}}", e.Country,
+		"{{proto}}", n.Proto,
+		"{{net}}", n.Transport,
+		"{{security}}", n.Security,
+		"{{host}}", n.Host,
+		"{{port}}", strconv.Itoa(n.Port),
+		"{{latency_ms}}", latency,
+		"{{success_pct}}", strconv.Itoa(int(e.SuccessRate*100+0.5)),
+		"{{origins}}", strconv.Itoa(e.OriginsOK),
+		"{{mbps}}", mbps,
+		"{{remark}}", n.Remark,
+		"{{index}}", strconv.Itoa(index),
+	)
+	// empty fields (no country, no transport) must not leave gaps behind
+	return strings.Join(strings.Fields(r.Replace(tmpl)), " ")
+}
+
+// Flag is the inverse of Country: "DE" -> "🇩🇪", "" for anything else.
+func Flag(country string) string {
+	if len(country) != 2 {
+		return ""
+	}
+	c := strings.ToUpper(country)
+	if c[0] < 'A' || c[0] > 'Z' || c[1] < 'A' || c[1] > 'Z' {
+		return ""
+	}
+	return string([]rune{0x1F1E6 + rune(c[0]-'A'), 0x1F1E6 + rune(c[1]-'A')})
+}
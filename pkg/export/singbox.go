@@ -0,0 +1,216 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/yasi-python/go/pkg/uri"
+)
+
+// ClientOptions configures the generated sing-box and Xray client configs.
+type ClientOptions struct {
+	TestURL         string
+	IntervalSeconds int
+	// ListenPort is the local mixed (sing-box) / socks (Xray) inbound port;
+	// Xray also listens for HTTP on ListenPort+1.
+	ListenPort int
+}
+
+func (o ClientOptions) withDefaults() ClientOptions {
+	if o.TestURL == "" {
+		o.TestURL = "https://www.gstatic.com/generate_204"
+	}
+	if o.IntervalSeconds <= 0 {
+		o.IntervalSeconds = 300
+	}
+	if o.ListenPort <= 0 {
+		o.ListenPort = 2080
+	}
+	return o
+}
+
+const (
+	singBoxSelectTag = "proxy"
+	singBoxAutoTag   = "auto"
+)
+
+type singBoxConfig struct {
+	Log       map[string]any   `json:"log"`
+	Inbounds  []map[string]any `json:"inbounds"`
+	Outbounds []map[string]any `json:"outbounds"`
+	Route     map[string]any   `json:"route"`
+}
+
+// SingBox renders entries as a sing-box client config: one outbound per
+// node, a urltest group over all of them and a selector defaulting to it.
+func SingBox(es []Entry, opt ClientOptions) ([]byte, error) {
+	opt = opt.withDefaults()
+	names := uniqueNames(es)
+	nodes := []map[string]any{}
+	tags := []string{}
+	for i, e := range es {
+		ob, ok := toSingBox(e.Node)
+		if !ok {
+			continue
+		}
+		ob["tag"] = names[i]
+		nodes = append(nodes, ob)
+		tags = append(tags, names[i])
+	}
+	members := tags
+	if len(members) == 0 {
+		members = []string{"direct"}
+	}
+	cfg := singBoxConfig{
+		Log: map[string]any{"level": "warn"},
+		Inbounds: []map[string]any{{
+			"type": "mixed", "tag": "mixed-in", "listen": "127.0.0.1", "listen_port": opt.ListenPort,
+		}},
+		Outbounds: []map[string]any{
+			{"type": "selector", "tag": singBoxSelectTag, "outbounds": append([]string{singBoxAutoTag}, tags...), "default": singBoxAutoTag},
+			{"type": "urltest", "tag": singBoxAutoTag, "outbounds": members, "url": opt.TestURL,
+				"interval": fmt.Sprintf("%ds", opt.IntervalSeconds), "tolerance": 50},
+		},
+		Route: map[string]any{"final": singBoxSelectTag, "auto_detect_interface": true},
+	}
+	cfg.Outbounds = append(cfg.Outbounds, nodes...)
+	cfg.Outbounds = append(cfg.Outbounds, map[string]any{"type": "direct", "tag": "direct"})
+	return marshalJSON(cfg)
+}
+
+// toSingBox maps a node onto a sing-box outbound; false means sing-box has
+// no equivalent (ssr, unknown transports or plugins).
+func toSingBox(n *uri.Node) (map[string]any, bool) {
+	ob := map[string]any{"server": n.Host, "server_port": n.Port}
+	switch n.Proto {
+	case "ss":
+		ob["type"], ob["method"], ob["password"] = "shadowsocks", n.Cipher, n.Password
+		switch n.Plugin {
+		case "":
+		case "obfs-local", "v2ray-plugin":
+			ob["plugin"], ob["plugin_opts"] = n.Plugin, n.PluginOpts
+		default:
+			return nil, false
+		}
+	case "vmess":
+		ob["type"], ob["uuid"], ob["security"], ob["alter_id"] = "vmess", n.UUID, or(n.Cipher, "auto"), n.AlterID
+	case "vless":
+		ob["type"], ob["uuid"] = "vless", n.UUID
+		if n.Flow != "" {
+			ob["flow"] = n.Flow
+		}
+	case "trojan":
+		ob["type"], ob["password"] = "trojan", n.Password
+	case "hysteria2":
+		ob["type"], ob["password"] = "hysteria2", n.Password
+		if n.Ports != "" {
+			ob["server_ports"] = singBoxPorts(n.Ports)
+		}
+		if n.Obfs != "" {
+			ob["obfs"] = map[string]any{"type": n.Obfs, "password": n.ObfsParam}
+		}
+	case "tuic":
+		ob["type"], ob["uuid"], ob["password"] = "tuic", n.UUID, n.Password
+		if n.CongestionControl != "" {
+			ob["congestion_control"] = n.CongestionControl
+		}
+		if n.UDPRelayMode != "" {
+			ob["udp_relay_mode"] = n.UDPRelayMode
+		}
+	case "wireguard":
+		ob["type"], ob["private_key"], ob["peer_public_key"] = "wireguard", n.PrivateKey, n.PublicKey
+		ob["local_address"] = n.LocalAddress
+		if n.PreSharedKey != "" {
+			ob["pre_shared_key"] = n.PreSharedKey
+		}
+		if n.MTU > 0 {
+			ob["mtu"] = n.MTU
+		}
+		if r := reservedBytes(n.Reserved); len(r) > 0 {
+			ob["reserved"] = r
+		}
+	case "socks5":
+		ob["type"], ob["version"] = "socks", "5"
+		if n.Username != "" {
+			ob["username"], ob["password"] = n.Username, n.Password
+		}
+	default:
+		return nil, false
+	}
+	switch n.Proto {
+	case "vmess", "vless", "trojan":
+		switch n.Transport {
+		case "", "tcp":
+		case "ws", "httpupgrade":
+			t := map[string]any{"type": n.Transport, "path": or(n.Path, "/")}
+			if n.HostHeader != "" {
+				if n.Transport == "ws" {
+					t["headers"] = map[string]string{"Host": n.HostHeader}
+				} else {
+					t["host"] = n.HostHeader
+				}
+			}
+			ob["transport"] = t
+		case "grpc":
+			ob["transport"] = map[string]any{"type": "grpc", "service_name": n.ServiceName}
+		case "h2":
+			t := map[string]any{"type": "http", "path": or(n.Path, "/")}
+			if n.HostHeader != "" {
+				t["host"] = []string{n.HostHeader}
+			}
+			ob["transport"] = t
+		default:
+			return nil, false
+		}
+	}
+	if n.TLS() || n.Proto == "hysteria2" || n.Proto == "tuic" {
+		t := map[string]any{"enabled": true, "server_name": n.ServerName()}
+		if n.AllowInsecure {
+			t["insecure"] = true
+		}
+		if len(n.ALPN) > 0 {
+			t["alpn"] = n.ALPN
+		}
+		fp := n.Fingerprint
+		if n.Security == "reality" {
+			// sing-box refuses reality without utls
+			fp = or(fp, "chrome")
+			t["reality"] = map[string]any{"enabled": true, "public_key": n.PublicKey, "short_id": n.ShortID}
+		}
+		if fp != "" {
+			t["utls"] = map[string]any{"enabled": true, "fingerprint": fp}
+		}
+		ob["tls"] = t
+	}
+	return ob, true
+}
+
+// singBoxPorts turns "443,5000-6000" into ["443:443", "5000:6000"].
+func singBoxPorts(spec string) []string {
+	out := []string{}
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if a, b, ok := strings.Cut(p, "-"); ok {
+			out = append(out, a+":"+b)
+		} else {
+			out = append(out, p+":"+p)
+		}
+	}
+	return out
+}
+
+func marshalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,178 @@
+package export
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/yasi-python/go/pkg/uri"
+)
+
+// xrayTagPrefix is shared by every node outbound so the balancer and the
+// observatory can select them by prefix.
+const xrayTagPrefix = "proxy-"
+
+type xrayConfig struct {
+	Log         map[string]any   `json:"log"`
+	Inbounds    []map[string]any `json:"inbounds"`
+	Outbounds   []map[string]any `json:"outbounds"`
+	Observatory map[string]any   `json:"observatory"`
+	Routing     map[string]any   `json:"routing"`
+}
+
+// Xray renders entries as an Xray client config: one outbound per node
+// and a leastPing balancer over them, fed by the observatory.
+func Xray(es []Entry, opt ClientOptions) ([]byte, error) {
+	opt = opt.withDefaults()
+	cfg := xrayConfig{
+		Log: map[string]any{"loglevel": "warning"},
+		Inbounds: []map[string]any{
+			{"tag": "socks-in", "protocol": "socks", "listen": "127.0.0.1", "port": opt.ListenPort,
+				"settings": map[string]any{"udp": true}},
+			{"tag": "http-in", "protocol": "http", "listen": "127.0.0.1", "port": opt.ListenPort + 1},
+		},
+		Observatory: map[string]any{
+			"subjectSelector": []string{xrayTagPrefix},
+			"probeURL":        opt.TestURL,
+			"probeInterval":   fmt.Sprintf("%ds", opt.IntervalSeconds),
+		},
+	}
+	for _, e := range es {
+		ob, ok := toXray(e.Node)
+		if !ok {
+			continue
+		}
+		// tags follow the latency order of es, so proxy-1 is the fastest
+		ob["tag"] = fmt.Sprintf("%s%d", xrayTagPrefix, len(cfg.Outbounds)+1)
+		cfg.Outbounds = append(cfg.Outbounds, ob)
+	}
+	rule := map[string]any{"type": "field", "network": "tcp,udp", "outboundTag": "direct"}
+	cfg.Routing = map[string]any{"domainStrategy": "AsIs", "rules": []map[string]any{rule}}
+	if len(cfg.Outbounds) > 0 {
+		delete(rule, "outboundTag")
+		rule["balancerTag"] = "balancer"
+		cfg.Routing["balancers"] = []map[string]any{{
+			"tag": "balancer", "selector": []string{xrayTagPrefix},
+			"strategy": map[string]any{"type": "leastPing"},
+		}}
+	}
+	cfg.Outbounds = append(cfg.Outbounds, map[string]any{"tag": "direct", "protocol": "freedom"})
+	return marshalJSON(cfg)
+}
+
+// toXray maps a node onto an Xray outbound; false means Xray cannot dial it
+// (hysteria2, tuic, ssr, SIP003 plugins).
+func toXray(n *uri.Node) (map[string]any, bool) {
+	ob := map[string]any{"protocol": n.Proto}
+	server := map[string]any{"address": n.Host, "port": n.Port}
+	switch n.Proto {
+	case "vmess":
+		server["users"] = []map[string]any{{"id": n.UUID, "alterId": n.AlterID, "security": or(n.Cipher, "auto")}}
+		ob["settings"] = map[string]any{"vnext": []map[string]any{server}}
+	case "vless":
+		u := map[string]any{"id": n.UUID, "encryption": or(n.Cipher, "none")}
+		if n.Flow != "" {
+			u["flow"] = n.Flow
+		}
+		server["users"] = []map[string]any{u}
+		ob["settings"] = map[string]any{"vnext": []map[string]any{server}}
+	case "trojan":
+		server["password"] = n.Password
+		ob["settings"] = map[string]any{"servers": []map[string]any{server}}
+	case "ss":
+		if n.Plugin != "" {
+			return nil, false
+		}
+		ob["protocol"] = "shadowsocks"
+		server["method"], server["password"] = n.Cipher, n.Password
+		ob["settings"] = map[string]any{"servers": []map[string]any{server}}
+	case "socks5":
+		ob["protocol"] = "socks"
+		if n.Username != "" {
+			server["users"] = []map[string]any{{"user": n.Username, "pass": n.Password}}
+		}
+		ob["settings"] = map[string]any{"servers": []map[string]any{server}}
+	case "wireguard":
+		peer := map[string]any{"publicKey": n.PublicKey, "endpoint": net.JoinHostPort(n.Host, strconv.Itoa(n.Port))}
+		if n.PreSharedKey != "" {
+			peer["preSharedKey"] = n.PreSharedKey
+		}
+		s := map[string]any{"secretKey": n.PrivateKey, "address": n.LocalAddress, "peers": []map[string]any{peer}}
+		if n.MTU > 0 {
+			s["mtu"] = n.MTU
+		}
+		if r := reservedBytes(n.Reserved); len(r) > 0 {
+			s["reserved"] = r
+		}
+		ob["settings"] = s
+		return ob, true
+	default:
+		return nil, false
+	}
+	ss, ok := xrayStream(n)
+	if !ok {
+		return nil, false
+	}
+	ob["streamSettings"] = ss
+	return ob, true
+}
+
+func xrayStream(n *uri.Node) (map[string]any, bool) {
+	ss := map[string]any{"network": or(n.Transport, "tcp"), "security": or(n.Security, "none")}
+	switch n.Transport {
+	case "", "tcp":
+		if n.HeaderType == "http" {
+			req := map[string]any{"path": []string{or(n.Path, "/")}}
+			if n.HostHeader != "" {
+				req["headers"] = map[string]any{"Host": []string{n.HostHeader}}
+			}
+			ss["tcpSettings"] = map[string]any{"header": map[string]any{"type": "http", "request": req}}
+		}
+	case "ws":
+		ws := map[string]any{"path": or(n.Path, "/")}
+		if n.HostHeader != "" {
+			ws["headers"] = map[string]string{"Host": n.HostHeader}
+		}
+		ss["wsSettings"] = ws
+	case "httpupgrade":
+		ss["httpupgradeSettings"] = map[string]any{"path": or(n.Path, "/"), "host": n.HostHeader}
+	case "grpc":
+		g := map[string]any{"serviceName": n.ServiceName}
+		if n.Mode == "multi" {
+			g["multiMode"] = true
+		}
+		ss["grpcSettings"] = g
+	case "h2":
+		h := map[string]any{"path": or(n.Path, "/")}
+		if n.HostHeader != "" {
+			h["host"] = []string{n.HostHeader}
+		}
+		ss["httpSettings"] = h
+	default:
+		return nil, false
+	}
+	switch n.Security {
+	case "tls":
+		t := map[string]any{"serverName": n.ServerName()}
+		if n.AllowInsecure {
+			t["allowInsecure"] = true
+		}
+		if len(n.ALPN) > 0 {
+			t["alpn"] = n.ALPN
+		}
+		if n.Fingerprint != "" {
+			t["fingerprint"] = n.Fingerprint
+		}
+		ss["tlsSettings"] = t
+	case "reality":
+		r := map[string]any{
+			"serverName": n.ServerName(), "publicKey": n.PublicKey, "shortId": n.ShortID,
+			"fingerprint": or(n.Fingerprint, "chrome"),
+		}
+		if n.SpiderX != "" {
+			r["spiderX"] = n.SpiderX
+		}
+		ss["realitySettings"] = r
+	}
+	return ss, true
+}
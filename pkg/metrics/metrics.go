@@ -6,6 +6,19 @@ var (
 	TotalProbes = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "v2mgr_probes_total", Help: "Total probes",
 	}, []string{"result"})
+	ProbeRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "v2mgr_probe_retries_total", Help: "Probe attempts repeated after a retriable failure",
+	})
+	ThrottledWaits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "v2mgr_probe_throttled_waits_total", Help: "Probes that queued on a per-target rate limit, by limiting scope",
+	}, []string{"scope"})
+	ThrottleWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "v2mgr_probe_throttle_wait_seconds", Help: "Time probes spent queued on per-target rate limits",
+		Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 30, 60},
+	})
+	ThroughputProbes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "v2mgr_throughput_probes_total", Help: "Bandwidth measurements by result (ok, fail)",
+	}, []string{"result"})
 	AvgLatency = prometheus.NewSummary(prometheus.SummaryOpts{
 		Name: "v2mgr_latency_seconds", Help: "Probe latency",
 	})
@@ -15,8 +28,17 @@ var (
 	Deletions = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "v2mgr_deletions_total", Help: "Total deletions",
 	})
+	ParseRejects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "v2mgr_parse_rejects_total", Help: "Nodes dropped at ingestion because they failed to parse",
+	}, []string{"proto", "reason"})
+	OutputWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "v2mgr_output_writes_total", Help: "Output file exports by result (written, unchanged, error)",
+	}, []string{"profile", "format", "result"})
+	OutputMode = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "v2mgr_output_mode", Help: "1 for the branch the last export took (healthy or a degraded policy)",
+	}, []string{"mode"})
 )
 
 func MustRegister() {
-	prometheus.MustRegister(TotalProbes, AvgLatency, Quarantines, Deletions)
+	prometheus.MustRegister(TotalProbes, ProbeRetries, ThrottledWaits, ThrottleWait, ThroughputProbes, AvgLatency, Quarantines, Deletions, ParseRejects, OutputWrites, OutputMode)
 }
\ No newline at end of file
@@ -0,0 +1,88 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+// dialOuter connects to the node and, when it uses TLS, completes the TLS
+// handshake with the node's SNI and the given ALPN. The connection's
+// deadline is the probe timeout, capped by ctx.
+func dialOuter(ctx context.Context, n Node, timeout time.Duration, alpn ...string) (net.Conn, error) {
+	d := net.Dialer{Timeout: timeout}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(n.Host, strconv.Itoa(n.Port)))
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	_ = conn.SetDeadline(deadline)
+	if !n.TLS {
+		return conn, nil
+	}
+	tc := tls.Client(conn, &tls.Config{ServerName: n.SNI, InsecureSkipVerify: true, NextProtos: alpn})
+	if err := tc.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// socksAddr encodes host:port the way SOCKS5 (RFC 1928 §5) does, which is
+// also the address format of shadowsocks and trojan requests.
+func socksAddr(target string) ([]byte, error) {
+	host, p, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, errors.New("invalid_port")
+	}
+	var b []byte
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append([]byte{1}, ip4...)
+		} else {
+			b = append([]byte{4}, ip.To16()...)
+		}
+	} else {
+		if len(host) == 0 || len(host) > 255 {
+			return nil, errors.New("invalid_host")
+		}
+		b = append([]byte{3, byte(len(host))}, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// transportSupported reports whether dialTransport speaks n's transport.
+func transportSupported(n Node) bool {
+	switch n.Transport {
+	case "", "tcp":
+		return n.HeaderType == ""
+	case "ws", "httpupgrade", "grpc", "h2":
+		return true
+	}
+	return false
+}
+
+// dialTransport opens the node's stream transport (raw, ws, httpupgrade,
+// grpc or h2) over its outer TLS, ready for a proxy protocol header.
+func dialTransport(ctx context.Context, n Node, timeout time.Duration) (net.Conn, error) {
+	switch n.Transport {
+	case "ws", "httpupgrade":
+		return wsDial(ctx, n, timeout)
+	case "grpc":
+		return gunDial(ctx, n, timeout)
+	case "h2":
+		return h2Dial(ctx, n, timeout)
+	}
+	return dialOuter(ctx, n, timeout)
+}
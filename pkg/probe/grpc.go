@@ -0,0 +1,121 @@
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// gunDial opens a gRPC "gun" tunnel (the v2ray/Xray grpc transport): one
+// bidirectional stream POSTed to /{serviceName}/Tun over HTTP/2. Data
+// travels as length-prefixed Hunk{bytes data = 1} messages both ways.
+func gunDial(ctx context.Context, n Node, timeout time.Duration) (net.Conn, error) {
+	s, err := openH2Stream(ctx, n, timeout, "POST", gunPath(n), gunHeader(), "grpc")
+	if err != nil {
+		return nil, err
+	}
+	return &gunConn{h2Stream: s}, nil
+}
+
+func gunPath(n Node) string { return "/" + n.ServiceName + "/Tun" }
+
+func gunHeader() http.Header {
+	return http.Header{
+		"Content-Type": {"application/grpc"},
+		"Te":           {"trailers"},
+		"User-Agent":   {"grpc-go/1.60.0"},
+	}
+}
+
+// grpcProbe opens a gun stream with an empty body. A gRPC server answers
+// for a service it routes (any grpc-status but 12, or an open stream);
+// an unknown serviceName comes back as 12 UNIMPLEMENTED, and a server
+// that is not gRPC fails on status or content type.
+func grpcProbe(ctx context.Context, n Node, timeout time.Duration) Result {
+	start := time.Now()
+	conn, cc, err := h2Client(ctx, n, timeout)
+	if err != nil {
+		return fail(err)
+	}
+	defer conn.Close()
+	defer cc.Close()
+	req, _ := http.NewRequestWithContext(ctx, "POST", h2URL(n, gunPath(n)), http.NoBody)
+	req.Header = gunHeader()
+	resp, err := cc.RoundTrip(req)
+	if err != nil {
+		return fail(err)
+	}
+	_ = resp.Body.Close()
+	switch {
+	case resp.StatusCode != http.StatusOK:
+		return Result{Success: false, Err: fmt.Sprintf("grpc_status_%d", resp.StatusCode), Retriable: resp.StatusCode >= 500}
+	case !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/grpc"):
+		return Result{Success: false, Err: "grpc_bad_content_type"}
+	case resp.Header.Get("Grpc-Status") == "12":
+		// trailers-only response: the status arrives with the headers
+		return Result{Success: false, Err: "grpc_unimplemented"}
+	}
+	return Result{Success: true, Latency: time.Since(start), Method: "grpc"}
+}
+
+// gunConn frames an h2Stream as gun Hunk messages.
+type gunConn struct {
+	*h2Stream
+	buf []byte
+}
+
+func (c *gunConn) Write(p []byte) (int, error) {
+	hunk := binary.AppendUvarint([]byte{0x0a}, uint64(len(p)))
+	msg := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(hunk)+len(p)))
+	msg = append(append(msg, hunk...), p...)
+	if _, err := c.h2Stream.Write(msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *gunConn) Read(p []byte) (int, error) {
+	body, err := c.response()
+	if err != nil {
+		return 0, err
+	}
+	for len(c.buf) == 0 {
+		var h [5]byte
+		if _, err := io.ReadFull(body, h[:]); err != nil {
+			return 0, err
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(h[1:]))
+		if _, err := io.ReadFull(body, msg); err != nil {
+			return 0, err
+		}
+		data, err := gunHunk(msg)
+		if err != nil {
+			return 0, err
+		}
+		c.buf = data
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// gunHunk extracts field 1 of a Hunk message.
+func gunHunk(msg []byte) ([]byte, error) {
+	if len(msg) == 0 {
+		return nil, nil
+	}
+	if msg[0] != 0x0a {
+		return nil, errors.New("grpc_bad_message")
+	}
+	size, k := binary.Uvarint(msg[1:])
+	if k <= 0 || uint64(len(msg)-1-k) < size {
+		return nil, errors.New("grpc_bad_message")
+	}
+	return msg[1+k : 1+k+int(size)], nil
+}
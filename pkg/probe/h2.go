@@ -0,0 +1,154 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// h2Client opens an HTTP/2 connection to the node: TLS with ALPN h2 when
+// the node uses TLS, prior-knowledge h2c otherwise. A TLS server that does
+// not select h2 cannot carry the grpc or h2 transports.
+func h2Client(ctx context.Context, n Node, timeout time.Duration) (net.Conn, *http2.ClientConn, error) {
+	conn, err := dialOuter(ctx, n, timeout, "h2")
+	if err != nil {
+		return nil, nil, err
+	}
+	if tc, ok := conn.(*tls.Conn); ok && tc.ConnectionState().NegotiatedProtocol != "h2" {
+		_ = conn.Close()
+		return nil, nil, errors.New("h2_not_negotiated")
+	}
+	cc, err := (&http2.Transport{AllowHTTP: true}).NewClientConn(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	return conn, cc, nil
+}
+
+// h2URL addresses path on the node with the authority its transport
+// expects: the first host header entry, else the SNI, else host:port.
+func h2URL(n Node, path string) string {
+	authority, _, _ := strings.Cut(n.HostHeader, ",")
+	authority = strings.TrimSpace(authority)
+	if authority == "" {
+		authority = n.SNI
+	}
+	if authority == "" {
+		authority = net.JoinHostPort(n.Host, fmt.Sprint(n.Port))
+	}
+	scheme := "http"
+	if n.TLS {
+		scheme = "https"
+	}
+	return (&url.URL{Scheme: scheme, Host: authority, Path: path}).String()
+}
+
+// h2Path is the h2 transport's request path; "/" when unset.
+func h2Path(n Node) string {
+	if n.Path == "" {
+		return "/"
+	}
+	return n.Path
+}
+
+// h2Probe sends the h2 transport's PUT with an empty body. Xray answers
+// 200 before reading the body and 404 for a host or path it does not
+// serve, which a bare TLS handshake would not show.
+func h2Probe(ctx context.Context, n Node, timeout time.Duration) Result {
+	start := time.Now()
+	conn, cc, err := h2Client(ctx, n, timeout)
+	if err != nil {
+		return fail(err)
+	}
+	defer conn.Close()
+	defer cc.Close()
+	req, _ := http.NewRequestWithContext(ctx, "PUT", h2URL(n, h2Path(n)), http.NoBody)
+	resp, err := cc.RoundTrip(req)
+	if err != nil {
+		return fail(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return Result{Success: false, Err: fmt.Sprintf("h2_status_%d", resp.StatusCode), Retriable: resp.StatusCode >= 500, Path: h2Path(n)}
+	}
+	return Result{Success: true, Latency: time.Since(start), Method: "h2", Path: h2Path(n)}
+}
+
+// h2Dial opens an h2 transport stream: a PUT to the node's path whose
+// request and response bodies carry the tunnel as is.
+func h2Dial(ctx context.Context, n Node, timeout time.Duration) (net.Conn, error) {
+	return openH2Stream(ctx, n, timeout, "PUT", h2Path(n), nil, "h2")
+}
+
+// h2Stream is one HTTP/2 request whose bodies carry a stream both ways.
+// The request goes out at once; Read waits for the response headers.
+// Deadlines apply to the underlying connection.
+type h2Stream struct {
+	net.Conn
+	cc    *http2.ClientConn
+	pw    *io.PipeWriter
+	ready chan struct{} // closed once response headers (or err) arrived
+	body  io.ReadCloser
+	err   error
+}
+
+// openH2Stream starts the request; a response status other than 200
+// fails the stream as "{prefix}_status_N".
+func openH2Stream(ctx context.Context, n Node, timeout time.Duration, method, path string, h http.Header, prefix string) (*h2Stream, error) {
+	conn, cc, err := h2Client(ctx, n, timeout)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	req, _ := http.NewRequestWithContext(ctx, method, h2URL(n, path), pr)
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	s := &h2Stream{Conn: conn, cc: cc, pw: pw, ready: make(chan struct{})}
+	go func() {
+		resp, err := cc.RoundTrip(req)
+		switch {
+		case err != nil:
+			s.err = err
+		case resp.StatusCode != http.StatusOK:
+			_ = resp.Body.Close()
+			s.err = fmt.Errorf("%s_status_%d", prefix, resp.StatusCode)
+		default:
+			s.body = resp.Body
+		}
+		close(s.ready)
+	}()
+	return s, nil
+}
+
+// response waits for the response headers and returns the body.
+func (s *h2Stream) response() (io.Reader, error) {
+	<-s.ready
+	return s.body, s.err
+}
+
+func (s *h2Stream) Write(p []byte) (int, error) { return s.pw.Write(p) }
+
+func (s *h2Stream) Read(p []byte) (int, error) {
+	body, err := s.response()
+	if err != nil {
+		return 0, err
+	}
+	return body.Read(p)
+}
+
+func (s *h2Stream) Close() error {
+	_ = s.pw.Close()
+	_ = s.cc.Close()
+	return s.Conn.Close()
+}
@@ -0,0 +1,201 @@
+package probe
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// handshakeSupported reports whether n can be probed with a real client
+// handshake. Plugins, flows, REALITY and transports we do not speak are
+// left to the reachability stages.
+func handshakeSupported(n Node) bool {
+	if n.Security == "reality" || !transportSupported(n) {
+		return false
+	}
+	switch n.Proto {
+	case "ss":
+		_, ok := ssMethods[n.Cipher]
+		return ok && n.Plugin == "" && (n.Transport == "" || n.Transport == "tcp")
+	case "trojan":
+		return true
+	case "vless":
+		return n.Flow == "" && (n.Cipher == "" || n.Cipher == "none")
+	case "vmess":
+		_, ok := vmessSecurity[n.Cipher]
+		return ok && n.AlterID == 0
+	}
+	return false
+}
+
+// dialThrough opens a stream to target (host:port) relayed by the node.
+func dialThrough(ctx context.Context, n Node, target string, timeout time.Duration) (net.Conn, error) {
+	addr, err := socksAddr(target)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialTransport(ctx, n, timeout)
+	if err != nil {
+		return nil, err
+	}
+	var pc net.Conn
+	switch n.Proto {
+	case "ss":
+		pc, err = ssClient(conn, n.Cipher, n.Password, addr)
+	case "trojan":
+		pc = trojanClient(conn, n.Password, addr)
+	case "vless":
+		pc, err = vlessClient(conn, n.UUID, target)
+	case "vmess":
+		pc, err = vmessClient(conn, n.UUID, n.Cipher, target)
+	default:
+		err = errors.New("unsupported_protocol")
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return pc, nil
+}
+
+// tunnelResponse is what a GET through the node returned. Connect is
+// the time to open the tunnel (TCP, TLS, transport); TTFB runs from there
+// to the first response byte, so it covers the proxy handshake and the
+// proxy's own connection to the target.
+type tunnelResponse struct {
+	Status  int
+	Connect time.Duration
+	TTFB    time.Duration
+}
+
+// stepError is a failure after the tunnel was opened, labelled with the step.
+type stepError struct {
+	step string
+	err  error
+}
+
+func (e *stepError) Error() string { return e.step + ": " + e.err.Error() }
+func (e *stepError) Unwrap() error { return e.err }
+
+// fetchThrough GETs rawURL through the node. read, if set, consumes the
+// body; conn is the tunnel, for deadlines.
+func fetchThrough(ctx context.Context, n Node, rawURL string, timeout time.Duration, read func(conn net.Conn, body io.Reader) error) (tunnelResponse, error) {
+	var tr tunnelResponse
+	start := time.Now()
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return tr, errors.New("invalid_probe_url")
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	conn, err := dialThrough(ctx, n, net.JoinHostPort(u.Hostname(), port), timeout)
+	if err != nil {
+		return tr, err
+	}
+	defer conn.Close()
+	tr.Connect = time.Since(start)
+	start = time.Now()
+	if u.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return tr, &stepError{"tls", err}
+		}
+		conn = tc
+	}
+	req, _ := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Close = true
+	if err := req.Write(conn); err != nil {
+		return tr, err
+	}
+	br := bufio.NewReader(conn)
+	if _, err := br.Peek(1); err != nil {
+		return tr, &stepError{"no_response", err}
+	}
+	tr.TTFB = time.Since(start)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return tr, &stepError{"no_response", err}
+	}
+	defer resp.Body.Close()
+	tr.Status = resp.StatusCode
+	if read != nil {
+		if err := read(conn, resp.Body); err != nil {
+			return tr, &stepError{"body", err}
+		}
+	}
+	return tr, nil
+}
+
+// handshakeProbe fetches opt.HandshakeURL through the node. Servers drop or
+// fall back (trojan serves its camouflage site) on bad credentials, so only
+// an HTTP answer below 400 relayed from the target proves the node works.
+// With opt.Fetch set, the content check then runs over a fresh tunnel.
+func handshakeProbe(ctx context.Context, n Node, opt Options) Result {
+	tr, err := fetchThrough(ctx, n, opt.HandshakeURL, opt.Timeout, nil)
+	if err != nil {
+		return handshakeFail(n.Proto, err)
+	}
+	if tr.Status >= 400 {
+		return Result{Success: false, Err: fmt.Sprintf("%s_status_%d", n.Proto, tr.Status)}
+	}
+	r := Result{Success: true, Latency: tr.Connect, TTFB: tr.TTFB, Method: n.Proto}
+	if opt.Fetch.URL == "" {
+		return r
+	}
+	return fetchProbe(ctx, n, opt, r)
+}
+
+// fetchProbe is the content check: a node that passes the handshake can
+// still black-hole or tamper with traffic. hs is the handshake result.
+func fetchProbe(ctx context.Context, n Node, opt Options, hs Result) Result {
+	fc := opt.Fetch
+	limit := fc.MaxBodyBytes
+	if limit <= 0 {
+		limit = 64 << 10
+	}
+	var body []byte
+	tr, err := fetchThrough(ctx, n, fc.URL, opt.Timeout, func(_ net.Conn, r io.Reader) (err error) {
+		body, err = io.ReadAll(io.LimitReader(r, limit+1))
+		return err
+	})
+	if err != nil {
+		return handshakeFail("fetch", err)
+	}
+	failed := func(e string) Result { return Result{Success: false, Err: e, TTFB: tr.TTFB} }
+	switch {
+	case fc.ExpectStatus != 0 && tr.Status != fc.ExpectStatus,
+		fc.ExpectStatus == 0 && (tr.Status < 200 || tr.Status > 299):
+		return failed(fmt.Sprintf("fetch_status_%d", tr.Status))
+	case fc.ExpectSHA256 != "" && int64(len(body)) > limit:
+		return failed("fetch_body_too_large")
+	case fc.ExpectBody != "" && !strings.Contains(string(body), fc.ExpectBody):
+		return failed("fetch_body_mismatch")
+	case fc.ExpectSHA256 != "" && !strings.EqualFold(fmt.Sprintf("%x", sha256.Sum256(body)), fc.ExpectSHA256):
+		return failed("fetch_hash_mismatch")
+	}
+	hs.TTFB = tr.TTFB
+	return hs
+}
+
+// handshakeFail labels an error after the tunnel was opened with the
+// protocol (or stage) and step, keeping its retry classification.
+func handshakeFail(prefix string, err error) Result {
+	r := fail(err)
+	var se *stepError
+	if errors.As(err, &se) {
+		r.Err = prefix + "_" + r.Err
+	}
+	return r
+}
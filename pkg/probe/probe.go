@@ -9,30 +9,96 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
 type Node struct {
-	ID    string
-	Raw   string
-	Proto string
-	Host  string
-	Port  int
-	Path  string
-	TLS   bool
-	SNI   string
+	ID         string
+	Raw        string
+	Proto      string
+	Host       string
+	Port       int
+	Path       string
+	TLS        bool
+	SNI        string
+	Transport  string
+	Security   string
+	HostHeader string
+	Obfs       string
+
+	// credentials for the authenticated handshake stage
+	Cipher      string
+	Password    string
+	Plugin      string
+	UUID        string
+	AlterID     int
+	Flow        string
+	ServiceName string
+	HeaderType  string
+
+	// REALITY server key (pbk) and short id (sid)
+	PublicKey string
+	ShortID   string
 }
 
+// ErrUntested is reported (as Result.Err, Method "untested") for nodes no
+// stage can check: no address, wireguard, and hysteria2/tuic behind
+// obfuscation. Like
+// ErrThrottled it is not a verdict and must not count as an attempt, or
+// such nodes would look healthy without ever being reached.
+var ErrUntested = errors.New("untested")
+
 type Result struct {
 	Success bool
+	// Latency is the connect time; for handshake stages the time to open
+	// the tunnel, with the rest of the exchange in TTFB.
 	Latency time.Duration
-	Method  string
-	Err     string
+	// TTFB is, for handshake stages, the time from the open tunnel to the
+	// first response byte (of the content check when it ran).
+	TTFB time.Duration
+	// Method is the stage that decided the result: the protocol name (ss,
+	// trojan, vless, vmess) for an authenticated handshake, reality (server
+	// auth checked against pbk), grpc or h2 (transport request answered),
+	// ws (validated RFC 6455 upgrade), http, tls, tcp, quic or untested.
+	Method string
+	// Path is the HTTP path of the ws/http/h2 stage, if that stage decided.
+	Path string
+	Err  string
+	// Retriable marks a failure another attempt might turn into a success.
+	Retriable bool
+	// Attempts is how many tries the result took (1 without retries).
+	Attempts int
 }
 
 type Options struct {
-	Timeout       time.Duration
-	HTTPProbePath string
+	Timeout time.Duration
+	// PreferHTTP runs the HTTP stage first for nodes with a ws transport or
+	// a path, over the node's path and then HTTPProbePaths.
+	PreferHTTP     bool
+	HTTPProbePaths []string
+	// Retries is the number of extra attempts after a retriable failure,
+	// spaced by a jittered backoff doubling from BackoffInitial up to BackoffMax.
+	Retries        int
+	BackoffInitial time.Duration
+	BackoffMax     time.Duration
+	// Limiter, if set, paces probes per target (one token per ProbeNode,
+	// retries included); shared by all probes.
+	Limiter *Limiter
+	// HandshakeURL, if set, is fetched through the node with a real
+	// protocol handshake where supported; that result then decides.
+	HandshakeURL string
+	// Fetch is an optional content check run after a successful handshake.
+	Fetch FetchCheck
+}
+
+// FetchCheck fetches URL through the tunnel and verifies the answer.
+type FetchCheck struct {
+	URL          string
+	ExpectStatus int    // 0: any 2xx
+	ExpectBody   string // substring the body must contain
+	ExpectSHA256 string // hex digest of the whole body
+	MaxBodyBytes int64  // read limit; default 64 KiB
 }
 
 func tcpProbe(ctx context.Context, host string, port int, timeout time.Duration) Result {
@@ -40,7 +106,7 @@ func tcpProbe(ctx context.Context, host string, port int, timeout time.Duration)
 	d := net.Dialer{Timeout: timeout}
 	conn, err := d.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", host, port))
 	if err != nil {
-		return Result{Success: false, Err: err.Error()}
+		return fail(err)
 	}
 	_ = conn.SetDeadline(time.Now().Add(timeout))
 	_ = conn.Close()
@@ -53,44 +119,71 @@ func tlsProbe(_ context.Context, host string, port int, sni string, timeout time
 	conn, err := tls.DialWithDialer(&d, "tcp", fmt.Sprintf("%s:%d", host, port),
 		&tls.Config{ServerName: sni, InsecureSkipVerify: true})
 	if err != nil {
-		return Result{Success: false, Err: err.Error()}
+		return fail(err)
 	}
 	_ = conn.SetDeadline(time.Now().Add(timeout))
 	_ = conn.Close()
 	return Result{Success: true, Latency: time.Since(start), Method: "tls"}
 }
 
-func httpProbe(ctx context.Context, host string, port int, path string, tlsOn bool, hostHeader string, timeout time.Duration) Result {
+// httpProbe requests path over the node's outer transport (TLS with the
+// node's SNI when set). Any answer below 400 shows an HTTP server there.
+func httpProbe(ctx context.Context, n Node, path string, timeout time.Duration) Result {
 	start := time.Now()
 	client := &http.Client{
 		Timeout: timeout,
 		Transport: &http.Transport{
 			Proxy:               http.ProxyFromEnvironment,
-			TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+			TLSClientConfig:     &tls.Config{ServerName: n.SNI, InsecureSkipVerify: true},
 			MaxIdleConnsPerHost: 10,
 		},
+		// a redirect is an answer from this server; do not chase it elsewhere
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
 	}
 	scheme := "http"
-	if tlsOn || port == 443 {
+	if n.TLS || n.Port == 443 {
 		scheme = "https"
 	}
-	if path == "" {
-		path = "/"
-	}
-	url := fmt.Sprintf("%s://%s:%d%s", scheme, host, port, path)
+	url := fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(n.Host, fmt.Sprint(n.Port)), path)
 	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	hostHeader := n.HostHeader
+	if hostHeader == "" {
+		hostHeader = n.SNI
+	}
 	if hostHeader != "" {
 		req.Host = hostHeader
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return Result{Success: false, Err: err.Error()}
+		return fail(err)
 	}
 	_ = resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		return Result{Success: false, Err: fmt.Sprintf("http_status_%d", resp.StatusCode)}
+		return Result{Success: false, Err: fmt.Sprintf("http_status_%d", resp.StatusCode), Retriable: resp.StatusCode >= 500, Path: path}
+	}
+	return Result{Success: true, Latency: time.Since(start), Method: "http", Path: path}
+}
+
+// probePaths lists the HTTP stage paths: the node's own path first, then
+// the configured ones, without repeats.
+func probePaths(n Node, opt Options) []string {
+	out := []string{}
+	for _, p := range append([]string{n.Path}, opt.HTTPProbePaths...) {
+		if p == "" {
+			continue
+		}
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		dup := false
+		for _, x := range out {
+			dup = dup || x == p
+		}
+		if !dup {
+			out = append(out, p)
+		}
 	}
-	return Result{Success: true, Latency: time.Since(start), Method: "http"}
+	return out
 }
 
 type Origin interface {
@@ -103,15 +196,65 @@ type LocalOrigin struct{}
 func (LocalOrigin) Name() string { return "local" }
 
 func (LocalOrigin) ProbeNode(ctx context.Context, n Node, opt Options) Result {
+	if n.Host != "" {
+		if err := opt.Limiter.Wait(ctx, n.Host, opt.Timeout); err != nil {
+			return Result{Success: false, Err: err.Error()}
+		}
+	}
+	return retry(ctx, opt, func() Result { return probeStages(ctx, n, opt) })
+}
+
+// probeStages is a single attempt: the strongest stage that succeeds wins,
+// otherwise the last failure is returned.
+func probeStages(ctx context.Context, n Node, opt Options) Result {
 	if n.Host == "" || n.Port == 0 {
-		return Result{Success: true, Latency: 0, Method: "untested"}
+		return Result{Success: false, Method: "untested", Err: ErrUntested.Error()}
 	}
 	timeout := opt.Timeout
+	switch n.Proto {
+	case "hysteria2", "tuic":
+		// QUIC-based: TCP/TLS stages would only ever see a closed port
+		if n.Obfs != "" {
+			return Result{Success: false, Method: "untested", Err: ErrUntested.Error()}
+		}
+		return quicProbe(ctx, n.Host, n.Port, timeout)
+	case "wireguard":
+		// silent to anything but a valid handshake initiation
+		return Result{Success: false, Method: "untested", Err: ErrUntested.Error()}
+	}
+	if n.Security == "reality" && n.PublicKey != "" {
+		// a plain TLS handshake proves nothing here: unauthenticated
+		// clients are relayed to the camouflage site
+		return realityProbe(ctx, n, timeout)
+	}
+	if opt.HandshakeURL != "" && handshakeSupported(n) {
+		return handshakeProbe(ctx, n, opt)
+	}
+	// the transport's own request catches a wrong serviceName, path or
+	// host that a bare TLS handshake would pass
+	switch n.Transport {
+	case "grpc":
+		return grpcProbe(ctx, n, timeout)
+	case "h2":
+		return h2Probe(ctx, n, timeout)
+	}
 	// prefer http if path/ws given
-	if n.Path != "" {
-		r := httpProbe(ctx, n.Host, n.Port, n.Path, n.TLS, n.SNI, timeout)
-		if r.Success {
-			return r
+	upgrade := n.Transport == "ws" || n.Transport == "httpupgrade"
+	if opt.PreferHTTP && (upgrade || n.Path != "") {
+		// the upgrade on the node's own path is the strongest signal short
+		// of a proxy handshake, and it decides: a CDN front answering 200
+		// on / says nothing about the backend behind a failed upgrade
+		if upgrade {
+			path := n.Path
+			if path == "" {
+				path = "/"
+			}
+			return wsProbe(ctx, n, path, timeout)
+		}
+		for _, p := range probePaths(n, opt) {
+			if r := httpProbe(ctx, n, p, timeout); r.Success {
+				return r
+			}
 		}
 	}
 	// TLS if needed
@@ -145,20 +288,38 @@ func (a AgentOrigin) ProbeNode(ctx context.Context, n Node, opt Options) Result
 	}
 	reqBody := map[string]any{
 		"id": n.ID, "raw": n.Raw, "proto": n.Proto, "host": n.Host, "port": n.Port,
-		"path": n.Path, "tls": n.TLS, "sni": n.SNI, "timeout_ms": opt.Timeout.Milliseconds(),
+		"path": n.Path, "tls": n.TLS, "sni": n.SNI, "transport": n.Transport, "security": n.Security,
+		"host_header": n.HostHeader, "obfs": n.Obfs, "timeout_ms": opt.Timeout.Milliseconds(),
+		"retries": opt.Retries, "backoff_initial_ms": opt.BackoffInitial.Milliseconds(),
+		"backoff_max_ms": opt.BackoffMax.Milliseconds(),
+		"prefer_http": opt.PreferHTTP, "http_probe_paths": opt.HTTPProbePaths,
+		"handshake_url": opt.HandshakeURL, "cipher": n.Cipher, "password": n.Password, "plugin": n.Plugin,
+		"fetch_url": opt.Fetch.URL, "fetch_expect_status": opt.Fetch.ExpectStatus, "fetch_expect_body": opt.Fetch.ExpectBody,
+		"fetch_expect_sha256": opt.Fetch.ExpectSHA256, "fetch_max_body_bytes": opt.Fetch.MaxBodyBytes,
+		"uuid": n.UUID, "alter_id": n.AlterID, "flow": n.Flow, "service_name": n.ServiceName, "header_type": n.HeaderType,
+		"public_key": n.PublicKey, "short_id": n.ShortID,
 	}
 	resp, err := doJSON(ctx, a.HTTP, a.URL+"/probe", a.Token, reqBody)
 	if err != nil {
-		return Result{Success: false, Err: err.Error()}
+		return fail(err)
 	}
 	ok, _ := resp["success"].(bool)
 	method, _ := resp["method"].(string)
 	errStr, _ := resp["err"].(string)
+	path, _ := resp["path"].(string)
 	lat := time.Duration(0)
 	if v, ok := resp["latency_ms"].(float64); ok {
 		lat = time.Duration(int64(v)) * time.Millisecond
 	}
-	return Result{Success: ok, Latency: lat, Method: "agent:" + method, Err: errStr}
+	ttfb := time.Duration(0)
+	if v, ok := resp["ttfb_ms"].(float64); ok {
+		ttfb = time.Duration(int64(v)) * time.Millisecond
+	}
+	attempts := 1
+	if v, ok := resp["attempts"].(float64); ok && v > 0 {
+		attempts = int(v)
+	}
+	return Result{Success: ok, Latency: lat, TTFB: ttfb, Method: "agent:" + method, Path: path, Err: errStr, Attempts: attempts}
 }
 
 func doJSON(ctx context.Context, c *http.Client, url string, token string, payload map[string]any) (map[string]any, error) {
@@ -0,0 +1,87 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// quicGreaseVersion follows the 0x?a?a?a?a pattern reserved by RFC 9000
+// §15 so that no server will ever accept it.
+const quicGreaseVersion = 0x1a2a3a4a
+
+// quicProbe sends a padded long-header packet with a reserved version. A
+// live QUIC listener (hysteria2, tuic) must answer with a Version
+// Negotiation packet echoing our connection IDs (RFC 9000 §6.1), which
+// proves the UDP port is served by QUIC without needing a full handshake.
+func quicProbe(ctx context.Context, host string, port int, timeout time.Duration) Result {
+	start := time.Now()
+	d := net.Dialer{Timeout: timeout}
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(host, fmt.Sprint(port)))
+	if err != nil {
+		return fail(err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	_ = conn.SetDeadline(deadline)
+
+	dcid := make([]byte, 8)
+	scid := make([]byte, 8)
+	_, _ = rand.Read(dcid)
+	_, _ = rand.Read(scid)
+	// servers ignore unknown-version packets smaller than 1200 bytes
+	pkt := make([]byte, 1200)
+	pkt[0] = 0xc0
+	binary.BigEndian.PutUint32(pkt[1:5], quicGreaseVersion)
+	pkt[5] = byte(len(dcid))
+	copy(pkt[6:], dcid)
+	pkt[14] = byte(len(scid))
+	copy(pkt[15:], scid)
+	if _, err := conn.Write(pkt); err != nil {
+		return fail(err)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		nr, err := conn.Read(buf)
+		if err != nil {
+			return fail(err)
+		}
+		if ok, err := isVersionNegotiation(buf[:nr], scid, dcid); ok {
+			return Result{Success: true, Latency: time.Since(start), Method: "quic"}
+		} else if err != nil {
+			return fail(err)
+		}
+	}
+}
+
+// isVersionNegotiation reports whether b is a VN packet addressed to our
+// connection. VN swaps the IDs: its DCID is our SCID and vice versa.
+func isVersionNegotiation(b, scid, dcid []byte) (bool, error) {
+	if len(b) < 7 || b[0]&0x80 == 0 {
+		return false, nil
+	}
+	if !bytes.Equal(b[1:5], []byte{0, 0, 0, 0}) {
+		return false, nil
+	}
+	dl := int(b[5])
+	if len(b) < 6+dl+1 || !bytes.Equal(b[6:6+dl], scid) {
+		return false, nil
+	}
+	sl := int(b[6+dl])
+	if len(b) < 7+dl+sl || !bytes.Equal(b[7+dl:7+dl+sl], dcid) {
+		return false, nil
+	}
+	if (len(b)-7-dl-sl)%4 != 0 {
+		return false, errors.New("quic_bad_version_list")
+	}
+	return true, nil
+}
@@ -0,0 +1,194 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrThrottled is reported (as Result.Err) when a target's rate limit
+// would not free up before the probe's deadline. It says nothing about
+// the node's health and must not be counted as a failure.
+var ErrThrottled = errors.New("throttled")
+
+// Limiter scopes: what a token bucket is keyed by.
+const (
+	ScopeIP    = "ip"    // resolved address
+	ScopeNet24 = "net24" // IPv4 /24 (IPv6 /48) of the resolved address
+	ScopeHost  = "host"  // hostname as written in the link
+)
+
+// Limiter is a set of token buckets, one per target key, shared by every
+// probe path so nodes behind the same address are probed at a bounded
+// rate. Probes queue for a token instead of bursting.
+type Limiter struct {
+	rate   float64 // tokens per second
+	burst  float64
+	scopes []string
+	// OnWait, if set, is called for every probe that had to queue.
+	OnWait func(scope string, d time.Duration)
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	dns     map[string]dnsEntry
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type dnsEntry struct {
+	ip      net.IP
+	expires time.Time
+}
+
+const (
+	dnsCacheTTL   = time.Minute
+	bucketsPruneN = 4096
+)
+
+// NewLimiter allows perMinute probes per key with the given burst, over the
+// given scopes (default ScopeIP). perMinute <= 0 returns nil, which never
+// throttles.
+func NewLimiter(perMinute, burst int, scopes []string) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	if len(scopes) == 0 {
+		scopes = []string{ScopeIP}
+	}
+	return &Limiter{
+		rate: float64(perMinute) / 60, burst: float64(burst), scopes: scopes,
+		buckets: map[string]*bucket{}, dns: map[string]dnsEntry{},
+	}
+}
+
+// Wait takes one token from every bucket host maps to, sleeping until they
+// are available. If that would take past ctx's deadline less reserve (the
+// time the probe itself needs), it returns ErrThrottled without waiting.
+func (l *Limiter) Wait(ctx context.Context, host string, reserve time.Duration) error {
+	if l == nil {
+		return nil
+	}
+	keys := l.keys(ctx, host)
+	now := time.Now()
+	l.mu.Lock()
+	var delay time.Duration
+	scope := ""
+	for _, k := range keys {
+		if d := l.take(k, now); d > delay {
+			delay, scope = d, k[:strings.IndexByte(k, ':')]
+		}
+	}
+	if dl, ok := ctx.Deadline(); ok && now.Add(delay+reserve).After(dl) {
+		for _, k := range keys {
+			l.buckets[k].tokens++
+		}
+		l.mu.Unlock()
+		return ErrThrottled
+	}
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	if l.OnWait != nil {
+		l.OnWait(scope, delay)
+	}
+	if !sleepCtx(ctx, delay) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// take removes a token from key's bucket, letting it go negative, and
+// returns how long until the token is actually earned. Callers hold l.mu.
+func (l *Limiter) take(key string, now time.Time) time.Duration {
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= bucketsPruneN {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / l.rate * float64(time.Second))
+}
+
+// prune drops buckets that have refilled completely; they would be
+// recreated in the same state.
+func (l *Limiter) prune(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func (l *Limiter) keys(ctx context.Context, host string) []string {
+	ip := l.resolve(ctx, host)
+	keys := make([]string, 0, len(l.scopes))
+	for _, s := range l.scopes {
+		switch s {
+		case ScopeHost:
+			keys = append(keys, "host:"+strings.ToLower(host))
+		case ScopeNet24:
+			if ip == nil {
+				continue
+			}
+			if v4 := ip.To4(); v4 != nil {
+				keys = append(keys, "net24:"+v4.Mask(net.CIDRMask(24, 32)).String())
+			} else {
+				keys = append(keys, "net24:"+ip.Mask(net.CIDRMask(48, 128)).String())
+			}
+		default:
+			if ip != nil {
+				keys = append(keys, "ip:"+ip.String())
+			} else {
+				// unresolvable: the name is the best identity we have
+				keys = append(keys, "ip:"+strings.ToLower(host))
+			}
+		}
+	}
+	return keys
+}
+
+// resolve maps host to its first address, caching lookups for a minute.
+func (l *Limiter) resolve(ctx context.Context, host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	now := time.Now()
+	l.mu.Lock()
+	e, ok := l.dns[host]
+	l.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.ip
+	}
+	var ip net.IP
+	if addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host); err == nil && len(addrs) > 0 {
+		ip = addrs[0].IP
+	}
+	l.mu.Lock()
+	if len(l.dns) >= bucketsPruneN {
+		l.dns = map[string]dnsEntry{}
+	}
+	l.dns[host] = dnsEntry{ip: ip, expires: now.Add(dnsCacheTTL)}
+	l.mu.Unlock()
+	return ip
+}
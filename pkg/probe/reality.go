@@ -0,0 +1,392 @@
+package probe
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/hkdf"
+)
+
+// realityVersion is the Xray version the session id claims; servers may
+// bound it with minClientVer/maxClientVer.
+var realityVersion = [3]byte{25, 1, 1}
+
+// errRealityFallback means the server answered with a certificate that is
+// not bound to our auth key: it relayed us to its camouflage site.
+var errRealityFallback = errors.New("reality_fallback")
+
+// realitySuite is a TLS 1.3 cipher suite: transcript hash, key size, AEAD.
+type realitySuite struct {
+	hash   func() hash.Hash
+	keyLen int
+	aead   func(key []byte) (cipher.AEAD, error)
+}
+
+var realitySuites = map[uint16]realitySuite{
+	0x1301: {sha256.New, 16, aesGCM},
+	0x1302: {sha512.New384, 32, aesGCM},
+	0x1303: {sha256.New, 32, chacha20poly1305.New},
+}
+
+// realityProbe runs the client side of a REALITY handshake up to the
+// server's certificate. The session id carries our short id sealed under
+// a key agreed with pbk; a server that accepts it presents a throwaway
+// ed25519 certificate whose signature is an HMAC under that key. Any
+// other certificate comes from the camouflage site the server relays
+// unauthenticated clients to. The ClientHello is a fixed Chrome-like one;
+// fp is not reproduced.
+func realityProbe(ctx context.Context, n Node, timeout time.Duration) Result {
+	start := time.Now()
+	d := net.Dialer{Timeout: timeout}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(n.Host, strconv.Itoa(n.Port)))
+	if err != nil {
+		return fail(err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	_ = conn.SetDeadline(deadline)
+	if err := realityHandshake(conn, n); err != nil {
+		return fail(err)
+	}
+	return Result{Success: true, Latency: time.Since(start), Method: "reality"}
+}
+
+func realityHandshake(conn net.Conn, n Node) error {
+	pbk, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(n.PublicKey, "="))
+	if err != nil {
+		return errors.New("invalid_public_key")
+	}
+	serverKey, err := ecdh.X25519().NewPublicKey(pbk)
+	if err != nil {
+		return errors.New("invalid_public_key")
+	}
+	shortID, err := hex.DecodeString(n.ShortID)
+	if err != nil || len(shortID) > 8 {
+		return errors.New("invalid_short_id")
+	}
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	authKey, err := priv.ECDH(serverKey)
+	if err != nil {
+		return errors.New("invalid_public_key")
+	}
+	hello := realityClientHello(n.SNI, priv.PublicKey().Bytes())
+	random := hello[6:38]
+	if _, err := io.ReadFull(hkdf.New(sha256.New, authKey, random[:20], []byte("REALITY")), authKey); err != nil {
+		return err
+	}
+	// session id: version, 0, unix time, short id; sealed with the hello
+	// (session id still zero) as additional data
+	plain := make([]byte, 16)
+	copy(plain, realityVersion[:])
+	binary.BigEndian.PutUint32(plain[4:], uint32(time.Now().Unix()))
+	copy(plain[8:], shortID)
+	aead, _ := aesGCM(authKey)
+	copy(hello[39:], aead.Seal(nil, random[20:], plain, hello))
+
+	rec := binary.BigEndian.AppendUint16([]byte{0x16, 3, 1}, uint16(len(hello)))
+	if _, err := conn.Write(append(rec, hello...)); err != nil {
+		return err
+	}
+	r := &realityReader{conn: conn}
+	sh, err := r.message()
+	if err != nil {
+		return err
+	}
+	suite, share, err := parseServerHello(sh)
+	if err != nil {
+		return err
+	}
+	peer, err := ecdh.X25519().NewPublicKey(share)
+	if err != nil {
+		return errors.New("reality_bad_server_hello")
+	}
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return errors.New("reality_bad_server_hello")
+	}
+
+	// TLS 1.3 key schedule (RFC 8446 §7.1) as far as the server's
+	// handshake traffic keys
+	hs := suite.hash().Size()
+	transcript := suite.hash()
+	transcript.Write(hello)
+	transcript.Write(sh)
+	early := hkdf.Extract(suite.hash, make([]byte, hs), nil)
+	empty := suite.hash().Sum(nil)
+	secret := hkdf.Extract(suite.hash, shared, expandLabel(suite.hash, early, "derived", empty, hs))
+	traffic := expandLabel(suite.hash, secret, "s hs traffic", transcript.Sum(nil), hs)
+	if r.aead, err = suite.aead(expandLabel(suite.hash, traffic, "key", nil, suite.keyLen)); err != nil {
+		return err
+	}
+	r.iv = expandLabel(suite.hash, traffic, "iv", nil, 12)
+
+	for {
+		m, err := r.message()
+		if err != nil {
+			return err
+		}
+		switch m[0] {
+		case 8, 13: // encrypted_extensions, certificate_request
+		case 11:
+			return verifyRealityCert(m[4:], authKey)
+		default:
+			return errors.New("reality_unexpected_message")
+		}
+	}
+}
+
+// realityClientHello builds a TLS 1.3 ClientHello handshake message with
+// a zeroed session id for the caller to fill in. Random is at [6:38],
+// the session id at [39:71].
+func realityClientHello(sni string, share []byte) []byte {
+	random := make([]byte, 32)
+	_, _ = rand.Read(random)
+	var b cryptobyte.Builder
+	b.AddUint8(1)
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(0x0303)
+		b.AddBytes(random)
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(make([]byte, 32)) })
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, s := range []uint16{0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8} {
+				b.AddUint16(s)
+			}
+		})
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint8(0) })
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			ext := func(typ uint16, body func(b *cryptobyte.Builder)) {
+				b.AddUint16(typ)
+				b.AddUint16LengthPrefixed(body)
+			}
+			if sni != "" && net.ParseIP(sni) == nil {
+				ext(0, func(b *cryptobyte.Builder) {
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+						b.AddUint8(0)
+						b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(sni)) })
+					})
+				})
+			}
+			ext(23, func(*cryptobyte.Builder) {})                      // extended_master_secret
+			ext(0xff01, func(b *cryptobyte.Builder) { b.AddUint8(0) }) // renegotiation_info
+			ext(10, func(b *cryptobyte.Builder) {                      // supported_groups
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint16(0x001d)
+					b.AddUint16(0x0017)
+					b.AddUint16(0x0018)
+				})
+			})
+			ext(11, func(b *cryptobyte.Builder) { b.AddBytes([]byte{1, 0}) }) // ec_point_formats
+			ext(35, func(*cryptobyte.Builder) {})                             // session_ticket
+			ext(16, func(b *cryptobyte.Builder) {                             // alpn
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					for _, p := range []string{"h2", "http/1.1"} {
+						b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(p)) })
+					}
+				})
+			})
+			ext(5, func(b *cryptobyte.Builder) { b.AddBytes([]byte{1, 0, 0, 0, 0}) }) // status_request
+			ext(13, func(b *cryptobyte.Builder) {                                     // signature_algorithms
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					// REALITY servers sign with ed25519 (0x0807)
+					for _, s := range []uint16{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601, 0x0807} {
+						b.AddUint16(s)
+					}
+				})
+			})
+			ext(18, func(*cryptobyte.Builder) {}) // signed_certificate_timestamp
+			ext(51, func(b *cryptobyte.Builder) { // key_share
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint16(0x001d)
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(share) })
+				})
+			})
+			ext(45, func(b *cryptobyte.Builder) { b.AddBytes([]byte{1, 1}) })    // psk_key_exchange_modes
+			ext(43, func(b *cryptobyte.Builder) { b.AddBytes([]byte{2, 3, 4}) }) // supported_versions: TLS 1.3
+		})
+	})
+	return b.BytesOrPanic()
+}
+
+// helloRetryRandom marks a HelloRetryRequest (RFC 8446 §4.1.3).
+var helloRetryRandom, _ = hex.DecodeString("cf21ad74e59a6111be1d8c021e65b891c2a211167abb8c5e079e09e2c8a8339c")
+
+// parseServerHello returns the negotiated suite and the server's x25519
+// key share.
+func parseServerHello(m []byte) (realitySuite, []byte, error) {
+	bad := errors.New("reality_bad_server_hello")
+	s := cryptobyte.String(m[4:])
+	var random, sid, exts cryptobyte.String
+	var version, suiteID uint16
+	var comp uint8
+	if m[0] != 2 || !s.ReadUint16(&version) || !s.ReadBytes((*[]byte)(&random), 32) ||
+		!s.ReadUint8LengthPrefixed(&sid) || !s.ReadUint16(&suiteID) || !s.ReadUint8(&comp) ||
+		!s.ReadUint16LengthPrefixed(&exts) {
+		return realitySuite{}, nil, bad
+	}
+	if string(random) == string(helloRetryRandom) {
+		return realitySuite{}, nil, errors.New("reality_hello_retry")
+	}
+	var share []byte
+	tls13 := false
+	for !exts.Empty() {
+		var typ uint16
+		var body cryptobyte.String
+		if !exts.ReadUint16(&typ) || !exts.ReadUint16LengthPrefixed(&body) {
+			return realitySuite{}, nil, bad
+		}
+		switch typ {
+		case 43:
+			var v uint16
+			tls13 = body.ReadUint16(&v) && v == 0x0304
+		case 51:
+			var group uint16
+			var key cryptobyte.String
+			if !body.ReadUint16(&group) || group != 0x001d || !body.ReadUint16LengthPrefixed(&key) {
+				return realitySuite{}, nil, bad
+			}
+			share = key
+		}
+	}
+	suite, ok := realitySuites[suiteID]
+	switch {
+	case !tls13:
+		return realitySuite{}, nil, errors.New("reality_not_tls13")
+	case !ok || share == nil:
+		return realitySuite{}, nil, bad
+	}
+	return suite, share, nil
+}
+
+// verifyRealityCert checks the leaf of a Certificate message: an ed25519
+// key signed with HMAC-SHA512(authKey, key) proves the REALITY server.
+func verifyRealityCert(body, authKey []byte) error {
+	s := cryptobyte.String(body)
+	var reqCtx, list, der cryptobyte.String
+	if !s.ReadUint8LengthPrefixed(&reqCtx) || !s.ReadUint24LengthPrefixed(&list) ||
+		!list.ReadUint24LengthPrefixed(&der) {
+		return errors.New("reality_bad_certificate")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return errors.New("reality_bad_certificate")
+	}
+	if pub, ok := cert.PublicKey.(ed25519.PublicKey); ok {
+		mac := hmac.New(sha512.New, authKey)
+		mac.Write(pub)
+		if hmac.Equal(mac.Sum(nil), cert.Signature) {
+			return nil
+		}
+	}
+	return errRealityFallback
+}
+
+// expandLabel is HKDF-Expand-Label of RFC 8446 §7.1.
+func expandLabel(h func() hash.Hash, secret []byte, label string, context []byte, length int) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(length))
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte("tls13 " + label)) })
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(context) })
+	out := make([]byte, length)
+	_, _ = io.ReadFull(hkdf.Expand(h, secret, b.BytesOrPanic()), out)
+	return out
+}
+
+// realityReader reassembles handshake messages from TLS records,
+// decrypting them once the handshake keys are set.
+type realityReader struct {
+	conn net.Conn
+	aead cipher.AEAD
+	iv   []byte
+	seq  uint64
+	buf  []byte
+}
+
+// message returns the next handshake message, header included.
+func (r *realityReader) message() ([]byte, error) {
+	for {
+		if len(r.buf) >= 4 {
+			l := 4 + (int(r.buf[1])<<16 | int(r.buf[2])<<8 | int(r.buf[3]))
+			if len(r.buf) >= l {
+				m := r.buf[:l]
+				r.buf = r.buf[l:]
+				return m, nil
+			}
+		}
+		if err := r.record(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (r *realityReader) record() error {
+	h := make([]byte, 5)
+	if _, err := io.ReadFull(r.conn, h); err != nil {
+		return err
+	}
+	p := make([]byte, binary.BigEndian.Uint16(h[3:]))
+	if len(p) > 16384+256 {
+		return errors.New("reality_bad_record")
+	}
+	if _, err := io.ReadFull(r.conn, p); err != nil {
+		return err
+	}
+	typ := h[0]
+	if r.aead != nil && typ == 0x17 {
+		nonce := append([]byte{}, r.iv...)
+		for i := 0; i < 8; i++ {
+			nonce[11-i] ^= byte(r.seq >> (8 * i))
+		}
+		r.seq++
+		pt, err := r.aead.Open(p[:0], nonce, p, h)
+		if err != nil {
+			return errors.New("reality_bad_record")
+		}
+		// strip padding; the last non-zero byte is the real content type
+		i := len(pt) - 1
+		for i >= 0 && pt[i] == 0 {
+			i--
+		}
+		if i < 0 {
+			return errors.New("reality_bad_record")
+		}
+		typ, p = pt[i], pt[:i]
+	}
+	switch typ {
+	case 0x14: // change_cipher_spec in middlebox compatibility mode
+		return nil
+	case 0x15:
+		if len(p) == 2 {
+			return fmt.Errorf("reality_alert_%d", p[1])
+		}
+	case 0x16:
+		r.buf = append(r.buf, p...)
+		return nil
+	}
+	return errors.New("reality_bad_record")
+}
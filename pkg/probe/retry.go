@@ -0,0 +1,89 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+// fail turns a stage error into a failed Result, classifying whether
+// another attempt could change the outcome.
+func fail(err error) Result {
+	return Result{Success: false, Err: err.Error(), Retriable: Retriable(err)}
+}
+
+// Retriable reports whether err looks transient (timeouts, resets, a DNS
+// server hiccup) rather than definitive (NXDOMAIN, connection refused,
+// unreachable network, TLS rejection). Unknown errors are definitive.
+func Retriable(err error) bool {
+	var dns *net.DNSError
+	if errors.As(err, &dns) {
+		return !dns.IsNotFound && (dns.IsTimeout || dns.IsTemporary)
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EHOSTUNREACH),
+		errors.Is(err, syscall.ENETUNREACH):
+		return false
+	case errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, context.DeadlineExceeded):
+		return true
+	}
+	var alert tls.AlertError
+	if errors.As(err, &alert) {
+		return false
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// retry runs attempt up to 1+opt.Retries times while it fails with a
+// retriable error, sleeping a jittered exponential backoff in between.
+// The returned Result carries the number of attempts made.
+func retry(ctx context.Context, opt Options, attempt func() Result) Result {
+	var r Result
+	backoff := opt.BackoffInitial
+	for i := 0; ; i++ {
+		r = attempt()
+		r.Attempts = i + 1
+		if r.Success || !r.Retriable || i >= opt.Retries {
+			return r
+		}
+		if !sleepCtx(ctx, jitter(backoff)) {
+			return r
+		}
+		backoff *= 2
+		if opt.BackoffMax > 0 && backoff > opt.BackoffMax {
+			backoff = opt.BackoffMax
+		}
+	}
+}
+
+// jitter picks uniformly from [d/2, d) so concurrent probes that failed
+// together do not retry in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,272 @@
+package probe
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"lukechampine.com/blake3"
+)
+
+// ssMethod is a shadowsocks AEAD method: the key (and salt) size, the AEAD
+// constructor, and whether it is a SIP022 (2022-blake3-*) method.
+type ssMethod struct {
+	keySize int
+	aead    func(key []byte) (cipher.AEAD, error)
+	v2022   bool
+}
+
+var ssMethods = map[string]ssMethod{
+	"aes-128-gcm":                   {16, aesGCM, false},
+	"aes-192-gcm":                   {24, aesGCM, false},
+	"aes-256-gcm":                   {32, aesGCM, false},
+	"chacha20-ietf-poly1305":        {32, chacha20poly1305.New, false},
+	"xchacha20-ietf-poly1305":       {32, chacha20poly1305.NewX, false},
+	"2022-blake3-aes-128-gcm":       {16, aesGCM, true},
+	"2022-blake3-aes-256-gcm":       {32, aesGCM, true},
+	"2022-blake3-chacha20-poly1305": {32, chacha20poly1305.New, true},
+}
+
+func aesGCM(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+const (
+	ssMaxChunk     = 0x3fff // SIP004 payload length limit
+	ss2022MaxChunk = 0xffff
+	// ss2022MaxSkew is how far a SIP022 response timestamp may drift.
+	ss2022MaxSkew = 30 * time.Second
+)
+
+// ssKey derives the master key: EVP_BytesToKey(MD5) of the password for
+// SIP004 methods, the base64 PSK itself for SIP022 ones.
+func ssKey(m ssMethod, password string) ([]byte, error) {
+	if m.v2022 {
+		if strings.Contains(password, ":") {
+			return nil, errors.New("ss2022_multi_user_unsupported")
+		}
+		psk, err := base64.StdEncoding.DecodeString(password)
+		if err != nil || len(psk) != m.keySize {
+			return nil, errors.New("ss2022_bad_psk")
+		}
+		return psk, nil
+	}
+	var key, prev []byte
+	for len(key) < m.keySize {
+		h := md5.Sum(append(prev, password...))
+		prev = h[:]
+		key = append(key, prev...)
+	}
+	return key[:m.keySize], nil
+}
+
+// ssSubkey derives the per-session key from the master key and a salt.
+func ssSubkey(m ssMethod, key, salt []byte) []byte {
+	sub := make([]byte, m.keySize)
+	if m.v2022 {
+		blake3.DeriveKey(sub, "shadowsocks 2022 session subkey", append(append([]byte{}, key...), salt...))
+		return sub
+	}
+	_, _ = io.ReadFull(hkdf.New(sha1.New, key, salt, []byte("ss-subkey")), sub)
+	return sub
+}
+
+// aeadStream seals or opens consecutive chunks with a little-endian
+// counter nonce starting at zero.
+type aeadStream struct {
+	cipher.AEAD
+	nonce []byte
+}
+
+func newAEADStream(m ssMethod, key, salt []byte) (*aeadStream, error) {
+	a, err := m.aead(ssSubkey(m, key, salt))
+	if err != nil {
+		return nil, err
+	}
+	return &aeadStream{AEAD: a, nonce: make([]byte, a.NonceSize())}, nil
+}
+
+func (s *aeadStream) seal(dst, p []byte) []byte {
+	dst = s.Seal(dst, s.nonce, p, nil)
+	s.next()
+	return dst
+}
+
+func (s *aeadStream) open(p []byte) ([]byte, error) {
+	out, err := s.Open(p[:0], s.nonce, p, nil)
+	s.next()
+	if err != nil {
+		return nil, errors.New("ss_bad_response")
+	}
+	return out, nil
+}
+
+func (s *aeadStream) next() {
+	for i := range s.nonce {
+		s.nonce[i]++
+		if s.nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+// ssConn is the client side of a shadowsocks AEAD TCP session. The
+// request header (target address) goes out with the first Write.
+type ssConn struct {
+	net.Conn
+	m       ssMethod
+	key     []byte
+	addr    []byte
+	reqSalt []byte
+	w, r    *aeadStream
+	buf     []byte
+}
+
+func ssClient(conn net.Conn, method, password string, addr []byte) (net.Conn, error) {
+	m, ok := ssMethods[method]
+	if !ok {
+		return nil, errors.New("unsupported_cipher:" + method)
+	}
+	key, err := ssKey(m, password)
+	if err != nil {
+		return nil, err
+	}
+	return &ssConn{Conn: conn, m: m, key: key, addr: addr}, nil
+}
+
+func (c *ssConn) Write(p []byte) (int, error) {
+	var out []byte
+	rest := p
+	if c.w == nil {
+		salt := make([]byte, c.m.keySize)
+		_, _ = rand.Read(salt)
+		w, err := newAEADStream(c.m, c.key, salt)
+		if err != nil {
+			return 0, err
+		}
+		c.w, c.reqSalt = w, salt
+		out = append(out, salt...)
+		if c.m.v2022 {
+			// SIP022: fixed header (type, timestamp, length), then the
+			// variable header carrying address, padding and first payload
+			head := append(append([]byte{}, c.addr...), 0, 0)
+			n := min(len(rest), ss2022MaxChunk-len(head))
+			if n == 0 {
+				// an empty first payload must be padded (1..900 bytes)
+				var r [2]byte
+				_, _ = rand.Read(r[:])
+				pad := 1 + int(binary.BigEndian.Uint16(r[:]))%900
+				binary.BigEndian.PutUint16(head[len(head)-2:], uint16(pad))
+				head = append(head, make([]byte, pad)...)
+			}
+			head = append(head, rest[:n]...)
+			rest = rest[n:]
+			fixed := []byte{0}
+			fixed = binary.BigEndian.AppendUint64(fixed, uint64(time.Now().Unix()))
+			fixed = binary.BigEndian.AppendUint16(fixed, uint16(len(head)))
+			out = c.w.seal(out, fixed)
+			out = c.w.seal(out, head)
+		} else {
+			rest = append(append([]byte{}, c.addr...), rest...)
+		}
+	}
+	limit := ssMaxChunk
+	if c.m.v2022 {
+		limit = ss2022MaxChunk
+	}
+	for len(rest) > 0 {
+		n := min(len(rest), limit)
+		out = c.w.seal(out, binary.BigEndian.AppendUint16(nil, uint16(n)))
+		out = c.w.seal(out, rest[:n])
+		rest = rest[n:]
+	}
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *ssConn) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if err := c.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// readChunk decrypts the next chunk into buf, reading the server salt
+// (and, for SIP022, the response header) first.
+func (c *ssConn) readChunk() error {
+	length := -1
+	if c.r == nil {
+		salt := make([]byte, c.m.keySize)
+		if _, err := io.ReadFull(c.Conn, salt); err != nil {
+			return err
+		}
+		r, err := newAEADStream(c.m, c.key, salt)
+		if err != nil {
+			return err
+		}
+		c.r = r
+		if c.m.v2022 {
+			fixed, err := c.readSealed(1 + 8 + c.m.keySize + 2)
+			if err != nil {
+				return err
+			}
+			ts := time.Unix(int64(binary.BigEndian.Uint64(fixed[1:9])), 0)
+			switch {
+			case fixed[0] != 1:
+				return errors.New("ss_bad_response")
+			case time.Since(ts).Abs() > ss2022MaxSkew:
+				return errors.New("ss2022_bad_timestamp")
+			case !bytes.Equal(fixed[9:9+c.m.keySize], c.reqSalt):
+				return errors.New("ss2022_bad_salt")
+			}
+			length = int(binary.BigEndian.Uint16(fixed[9+c.m.keySize:]))
+		}
+	}
+	if length < 0 {
+		l, err := c.readSealed(2)
+		if err != nil {
+			return err
+		}
+		length = int(binary.BigEndian.Uint16(l))
+		if !c.m.v2022 {
+			length &= ssMaxChunk
+		}
+	}
+	b, err := c.readSealed(length)
+	if err != nil {
+		return err
+	}
+	c.buf = b
+	return nil
+}
+
+// readSealed reads and opens one sealed chunk of n plaintext bytes.
+func (c *ssConn) readSealed(n int) ([]byte, error) {
+	b := make([]byte, n+c.r.Overhead())
+	if _, err := io.ReadFull(c.Conn, b); err != nil {
+		return nil, err
+	}
+	return c.r.open(b)
+}
@@ -0,0 +1,66 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// ThroughputCheck bounds a bandwidth measurement.
+type ThroughputCheck struct {
+	URL         string
+	MaxBytes    int64         // default 10 MiB
+	MaxDuration time.Duration // download window; default 10s
+}
+
+// ThroughputResult is one bounded download through a node.
+type ThroughputResult struct {
+	BytesPerSec float64
+	Bytes       int64
+	Duration    time.Duration
+	Err         string
+}
+
+// SupportsHandshake reports whether n can be tunnelled through, which the
+// handshake stage and Throughput need.
+func SupportsHandshake(n Node) bool { return handshakeSupported(n) }
+
+// Throughput downloads tc.URL through n until MaxBytes or MaxDuration is
+// reached. The rate is taken over the body only, so connect and handshake
+// latency do not drag it down; hitting the time cap is not an error.
+func Throughput(ctx context.Context, n Node, tc ThroughputCheck, timeout time.Duration) ThroughputResult {
+	if !handshakeSupported(n) {
+		return ThroughputResult{Err: "tunnel_unsupported"}
+	}
+	if tc.MaxBytes <= 0 {
+		tc.MaxBytes = 10 << 20
+	}
+	if tc.MaxDuration <= 0 {
+		tc.MaxDuration = 10 * time.Second
+	}
+	var res ThroughputResult
+	tr, err := fetchThrough(ctx, n, tc.URL, timeout, func(conn net.Conn, body io.Reader) error {
+		start := time.Now()
+		_ = conn.SetDeadline(start.Add(tc.MaxDuration))
+		nr, err := io.Copy(io.Discard, io.LimitReader(body, tc.MaxBytes))
+		res.Bytes, res.Duration = nr, time.Since(start)
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() && nr > 0 {
+			return nil
+		}
+		return err
+	})
+	switch {
+	case err != nil:
+		return ThroughputResult{Err: handshakeFail("throughput", err).Err}
+	case tr.Status < 200 || tr.Status > 299:
+		return ThroughputResult{Err: fmt.Sprintf("throughput_status_%d", tr.Status)}
+	case res.Bytes == 0:
+		return ThroughputResult{Err: "throughput_empty"}
+	}
+	res.BytesPerSec = float64(res.Bytes) / max(res.Duration.Seconds(), 1e-3)
+	return res
+}
@@ -0,0 +1,37 @@
+package probe
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+)
+
+// trojanConn is the client side of a trojan session over an established
+// TLS connection. The request header goes out with the first Write.
+type trojanConn struct {
+	net.Conn
+	header []byte
+}
+
+// trojanClient builds the request header: hex(SHA-224(password)), CRLF,
+// CONNECT, the SOCKS5-style target address, CRLF.
+func trojanClient(conn net.Conn, password string, addr []byte) net.Conn {
+	sum := sha256.Sum224([]byte(password))
+	h := []byte(hex.EncodeToString(sum[:]))
+	h = append(h, '\r', '\n', 1)
+	h = append(h, addr...)
+	h = append(h, '\r', '\n')
+	return &trojanConn{Conn: conn, header: h}
+}
+
+func (c *trojanConn) Write(p []byte) (int, error) {
+	if c.header == nil {
+		return c.Conn.Write(p)
+	}
+	b := append(c.header, p...)
+	c.header = nil
+	if _, err := c.Conn.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
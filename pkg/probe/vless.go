@@ -0,0 +1,96 @@
+package probe
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strings"
+)
+
+// parseUUID reads a user id. Like Xray, a non-UUID string of 1..30 bytes
+// stands for its UUIDv5 in the zero namespace.
+func parseUUID(s string) ([16]byte, error) {
+	var id [16]byte
+	h := strings.ReplaceAll(s, "-", "")
+	if len(h) == 32 {
+		if _, err := hex.Decode(id[:], []byte(h)); err == nil {
+			return id, nil
+		}
+	}
+	if len(s) == 0 || len(s) > 30 {
+		return id, errors.New("invalid_uuid")
+	}
+	sum := sha1.Sum(append(make([]byte, 16), s...))
+	copy(id[:], sum[:16])
+	id[6] = id[6]&0x0f | 0x50
+	id[8] = id[8]&0x3f | 0x80
+	return id, nil
+}
+
+// portFirstAddr re-encodes a SOCKS5 address the way VLESS and VMess
+// headers carry it: port, then type (1 IPv4, 2 domain, 3 IPv6), then address.
+func portFirstAddr(target string) ([]byte, error) {
+	s, err := socksAddr(target)
+	if err != nil {
+		return nil, err
+	}
+	atyp := map[byte]byte{1: 1, 3: 2, 4: 3}[s[0]]
+	out := append([]byte{}, s[len(s)-2:]...)
+	out = append(out, atyp)
+	return append(out, s[1:len(s)-2]...), nil
+}
+
+// vlessConn is the client side of a VLESS session (no flow). The request
+// header goes out with the first Write; the response header is consumed
+// by the first Read.
+type vlessConn struct {
+	net.Conn
+	header []byte
+	read   bool
+}
+
+func vlessClient(conn net.Conn, uuid, target string) (net.Conn, error) {
+	id, err := parseUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := portFirstAddr(target)
+	if err != nil {
+		return nil, err
+	}
+	// version 0, user id, no addons, command 1 (TCP), address
+	h := append([]byte{0}, id[:]...)
+	h = append(h, 0, 1)
+	return &vlessConn{Conn: conn, header: append(h, addr...)}, nil
+}
+
+func (c *vlessConn) Write(p []byte) (int, error) {
+	if c.header == nil {
+		return c.Conn.Write(p)
+	}
+	b := append(c.header, p...)
+	c.header = nil
+	if _, err := c.Conn.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *vlessConn) Read(p []byte) (int, error) {
+	if !c.read {
+		var h [2]byte
+		if _, err := io.ReadFull(c.Conn, h[:]); err != nil {
+			return 0, err
+		}
+		if h[0] != 0 {
+			return 0, errors.New("vless_bad_response")
+		}
+		if _, err := io.CopyN(io.Discard, c.Conn, int64(h[1])); err != nil {
+			return 0, err
+		}
+		c.read = true
+	}
+	return c.Conn.Read(p)
+}
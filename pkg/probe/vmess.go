@@ -0,0 +1,249 @@
+package probe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// vmessSecurity maps link ciphers to header security codes; auto resolves
+// to aes-128-gcm as Xray does on 64-bit hosts.
+var vmessSecurity = map[string]byte{
+	"auto": 3, "aes-128-gcm": 3, "chacha20-poly1305": 4, "none": 5, "zero": 6,
+}
+
+// vmessKDF is the nested HMAC-SHA256 key derivation of VMess AEAD.
+func vmessKDF(key []byte, path ...string) []byte {
+	h := func() hash.Hash { return hmac.New(sha256.New, []byte("VMess AEAD KDF")) }
+	for _, p := range path {
+		parent, p := h, p
+		h = func() hash.Hash { return hmac.New(parent, []byte(p)) }
+	}
+	m := h()
+	m.Write(key)
+	return m.Sum(nil)
+}
+
+func gcmSeal(key, nonce, p, aad []byte) []byte {
+	a, _ := aesGCM(key)
+	return a.Seal(nil, nonce, p, aad)
+}
+
+func gcmOpen(key, nonce, p, aad []byte) ([]byte, error) {
+	a, _ := aesGCM(key)
+	out, err := a.Open(nil, nonce, p, aad)
+	if err != nil {
+		return nil, errors.New("vmess_bad_response")
+	}
+	return out, nil
+}
+
+// vmessBody frames the payload in chunks (option ChunkStream): a 2-byte
+// length, then the sealed chunk. Nonces are a 2-byte count plus iv[2:12].
+type vmessBody struct {
+	aead  cipher.AEAD // nil for security none
+	iv    []byte
+	count uint16
+}
+
+func newVMessBody(sec byte, key, iv []byte) *vmessBody {
+	b := &vmessBody{iv: iv}
+	switch sec {
+	case 3:
+		b.aead, _ = aesGCM(key)
+	case 4:
+		k := md5.Sum(key)
+		k2 := md5.Sum(k[:])
+		b.aead, _ = chacha20poly1305.New(append(k[:], k2[:]...))
+	}
+	return b
+}
+
+func (b *vmessBody) nonce() []byte {
+	n := binary.BigEndian.AppendUint16(nil, b.count)
+	b.count++
+	return append(n, b.iv[2:12]...)
+}
+
+func (b *vmessBody) seal(dst, p []byte) []byte {
+	if b.aead == nil {
+		return append(binary.BigEndian.AppendUint16(dst, uint16(len(p))), p...)
+	}
+	ct := b.aead.Seal(nil, b.nonce(), p, nil)
+	return append(binary.BigEndian.AppendUint16(dst, uint16(len(ct))), ct...)
+}
+
+func (b *vmessBody) read(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	p := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, err
+	}
+	if b.aead == nil {
+		return p, nil
+	}
+	out, err := b.aead.Open(p[:0], b.nonce(), p, nil)
+	if err != nil {
+		return nil, errors.New("vmess_bad_response")
+	}
+	return out, nil
+}
+
+// vmessConn is the client side of a VMess AEAD session (alterId 0). The
+// sealed request header goes out with the first Write; the first Read
+// checks the response header against the response auth byte.
+type vmessConn struct {
+	net.Conn
+	header          []byte
+	respKey, respIV []byte
+	respV           byte
+	w, r            *vmessBody // nil for security zero: a raw stream
+	read            bool
+	buf             []byte
+}
+
+func vmessClient(conn net.Conn, uuid, cipherName, target string) (net.Conn, error) {
+	sec, ok := vmessSecurity[cipherName]
+	if !ok {
+		return nil, errors.New("unsupported_cipher:" + cipherName)
+	}
+	id, err := parseUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := portFirstAddr(target)
+	if err != nil {
+		return nil, err
+	}
+	cmdKey := md5.Sum(append(id[:], "c48619fe-8f02-49e0-b9e9-edf763e17e21"...))
+
+	keys := make([]byte, 33)
+	_, _ = rand.Read(keys)
+	reqIV, reqKey, respV := keys[:16], keys[16:32], keys[32]
+	option := byte(0x01) // ChunkStream
+	if sec == 6 {
+		option = 0
+	}
+	h := []byte{1}
+	h = append(h, reqIV...)
+	h = append(h, reqKey...)
+	h = append(h, respV, option, sec, 0, 1)
+	h = append(h, addr...)
+	f := fnv.New32a()
+	f.Write(h)
+	h = f.Sum(h)
+
+	// auth id: timestamp, random, crc32, encrypted as one AES block
+	var aid [16]byte
+	binary.BigEndian.PutUint64(aid[:8], uint64(time.Now().Unix()))
+	_, _ = rand.Read(aid[8:12])
+	binary.BigEndian.PutUint32(aid[12:], crc32.ChecksumIEEE(aid[:12]))
+	block, _ := aes.NewCipher(vmessKDF(cmdKey[:], "AES Auth ID Encryption")[:16])
+	authID := make([]byte, 16)
+	block.Encrypt(authID, aid[:])
+
+	nonce := make([]byte, 8)
+	_, _ = rand.Read(nonce)
+	aidS, nonceS := string(authID), string(nonce)
+	out := append([]byte{}, authID...)
+	out = append(out, gcmSeal(vmessKDF(cmdKey[:], "VMess Header AEAD Key_Length", aidS, nonceS)[:16],
+		vmessKDF(cmdKey[:], "VMess Header AEAD Nonce_Length", aidS, nonceS)[:12],
+		binary.BigEndian.AppendUint16(nil, uint16(len(h))), authID)...)
+	out = append(out, nonce...)
+	out = append(out, gcmSeal(vmessKDF(cmdKey[:], "VMess Header AEAD Key", aidS, nonceS)[:16],
+		vmessKDF(cmdKey[:], "VMess Header AEAD Nonce", aidS, nonceS)[:12], h, authID)...)
+
+	rk := sha256.Sum256(reqKey)
+	ri := sha256.Sum256(reqIV)
+	c := &vmessConn{Conn: conn, header: out, respKey: rk[:16], respIV: ri[:16], respV: respV}
+	if sec != 6 {
+		c.w = newVMessBody(sec, reqKey, reqIV)
+		c.r = newVMessBody(sec, c.respKey, c.respIV)
+	}
+	return c, nil
+}
+
+func (c *vmessConn) Write(p []byte) (int, error) {
+	out := c.header
+	c.header = nil
+	if c.w == nil {
+		out = append(out, p...)
+	} else {
+		for rest := p; len(rest) > 0; {
+			n := min(len(rest), 1<<14)
+			out = c.w.seal(out, rest[:n])
+			rest = rest[n:]
+		}
+	}
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *vmessConn) Read(p []byte) (int, error) {
+	if !c.read {
+		if err := c.readHeader(); err != nil {
+			return 0, err
+		}
+		c.read = true
+	}
+	if c.r == nil {
+		return c.Conn.Read(p)
+	}
+	for len(c.buf) == 0 {
+		b, err := c.r.read(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		if len(b) == 0 {
+			// an empty chunk ends the stream
+			return 0, io.EOF
+		}
+		c.buf = b
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *vmessConn) readHeader() error {
+	l := make([]byte, 2+16)
+	if _, err := io.ReadFull(c.Conn, l); err != nil {
+		return err
+	}
+	l, err := gcmOpen(vmessKDF(c.respKey, "AEAD Resp Header Len Key")[:16],
+		vmessKDF(c.respIV, "AEAD Resp Header Len IV")[:12], l, nil)
+	if err != nil {
+		return err
+	}
+	h := make([]byte, int(binary.BigEndian.Uint16(l))+16)
+	if _, err := io.ReadFull(c.Conn, h); err != nil {
+		return err
+	}
+	h, err = gcmOpen(vmessKDF(c.respKey, "AEAD Resp Header Key")[:16],
+		vmessKDF(c.respIV, "AEAD Resp Header IV")[:12], h, nil)
+	if err != nil {
+		return err
+	}
+	if len(h) < 4 || h[0] != c.respV {
+		return errors.New("vmess_bad_response")
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package probe
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// wsGUID is the fixed suffix of RFC 6455 §1.3 accept-key derivation.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsAccept is the Sec-WebSocket-Accept a server must return for key.
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// wsStatusError is a non-101 answer to an upgrade request.
+type wsStatusError int
+
+func (e wsStatusError) Error() string { return fmt.Sprintf("ws_status_%d", int(e)) }
+
+// wsProbe performs the RFC 6455 opening handshake against the node's ws
+// path: TLS with the node's SNI when enabled, the node's Host header, and a
+// random key whose Sec-WebSocket-Accept must come back correctly. Only a
+// valid 101 succeeds; a 101 with a wrong accept (a middlebox faking the
+// upgrade) fails with ws_bad_accept. v2ray's httpupgrade transport does not
+// echo the key, so for it any 101 with Upgrade: websocket is enough.
+func wsProbe(ctx context.Context, n Node, path string, timeout time.Duration) Result {
+	start := time.Now()
+	conn, err := dialOuter(ctx, n, timeout, "http/1.1")
+	if err != nil {
+		return fail(err)
+	}
+	defer conn.Close()
+	if _, err := wsUpgrade(conn, n, path); err != nil {
+		var se wsStatusError
+		if errors.As(err, &se) {
+			return Result{Success: false, Err: se.Error(), Retriable: se >= 500, Path: path}
+		}
+		r := fail(err)
+		r.Path = path
+		return r
+	}
+	return Result{Success: true, Latency: time.Since(start), Method: "ws", Path: path}
+}
+
+// wsUpgrade sends the opening handshake on conn and validates the answer.
+// The returned reader holds anything the server sent after the 101.
+func wsUpgrade(conn net.Conn, n Node, path string) (*bufio.Reader, error) {
+	host := n.HostHeader
+	if host == "" {
+		host = n.SNI
+	}
+	if host == "" {
+		host = net.JoinHostPort(n.Host, fmt.Sprint(n.Port))
+	}
+	k := make([]byte, 16)
+	_, _ = rand.Read(k)
+	key := base64.StdEncoding.EncodeToString(k)
+	req := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + host + "\r\n" +
+		"User-Agent: Mozilla/5.0\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, wsStatusError(resp.StatusCode)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		return nil, errors.New("ws_bad_upgrade")
+	}
+	if n.Transport != "httpupgrade" && resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		return nil, errors.New("ws_bad_accept")
+	}
+	return br, nil
+}
+
+// wsDial opens a ws (or httpupgrade) tunnel to the node's path. The
+// early-data hint (?ed=) of Xray links is dropped; it is only an optimisation.
+func wsDial(ctx context.Context, n Node, timeout time.Duration) (net.Conn, error) {
+	path := n.Path
+	if u, err := url.Parse(path); err == nil && u.Query().Has("ed") {
+		q := u.Query()
+		q.Del("ed")
+		u.RawQuery = q.Encode()
+		path = u.RequestURI()
+	}
+	if path == "" {
+		path = "/"
+	}
+	conn, err := dialOuter(ctx, n, timeout, "http/1.1")
+	if err != nil {
+		return nil, err
+	}
+	br, err := wsUpgrade(conn, n, path)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if n.Transport == "httpupgrade" {
+		return &bufConn{Conn: conn, br: br}, nil
+	}
+	return &wsConn{Conn: conn, br: br}, nil
+}
+
+// bufConn reads through br, which may hold bytes read past a handshake.
+type bufConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *bufConn) Read(p []byte) (int, error) { return c.br.Read(p) }
+
+// wsConn carries a byte stream in binary frames: masked on the way out as
+// RFC 6455 §5.3 requires of clients, unmasked on the way in.
+type wsConn struct {
+	net.Conn
+	br     *bufio.Reader
+	remain uint64 // unread payload of the current data frame
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	f := []byte{0x82}
+	switch {
+	case len(p) < 126:
+		f = append(f, 0x80|byte(len(p)))
+	case len(p) <= 0xffff:
+		f = binary.BigEndian.AppendUint16(append(f, 0x80|126), uint16(len(p)))
+	default:
+		f = binary.BigEndian.AppendUint64(append(f, 0x80|127), uint64(len(p)))
+	}
+	var mask [4]byte
+	_, _ = rand.Read(mask[:])
+	f = append(f, mask[:]...)
+	for i, b := range p {
+		f = append(f, b^mask[i%4])
+	}
+	if _, err := c.Conn.Write(f); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for c.remain == 0 {
+		var h [2]byte
+		if _, err := io.ReadFull(c.br, h[:]); err != nil {
+			return 0, err
+		}
+		if h[1]&0x80 != 0 {
+			return 0, errors.New("ws_masked_frame")
+		}
+		size := uint64(h[1] & 0x7f)
+		switch size {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(c.br, b[:]); err != nil {
+				return 0, err
+			}
+			size = uint64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(c.br, b[:]); err != nil {
+				return 0, err
+			}
+			size = binary.BigEndian.Uint64(b[:])
+		}
+		switch op := h[0] & 0x0f; {
+		case op == 8:
+			return 0, io.EOF
+		case op >= 8:
+			// ping/pong: nothing to answer within a probe's lifetime
+			if _, err := c.br.Discard(int(size)); err != nil {
+				return 0, err
+			}
+		default:
+			c.remain = size
+		}
+	}
+	if uint64(len(p)) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err := c.br.Read(p)
+	c.remain -= uint64(n)
+	return n, err
+}
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	bolt "go.etcd.io/bbolt"
+
+	"github.com/yasi-python/go/pkg/uri"
 )
 
 var (
@@ -33,7 +35,7 @@ func Open(path string) (*DB, error) {
 		if _, e := tx.CreateBucketIfNotExists(bucketConfigs); e != nil { return e }
 		if _, e := tx.CreateBucketIfNotExists(bucketStats); e != nil { return e }
 		if _, e := tx.CreateBucketIfNotExists(bucketState); e != nil { return e }
-		return nil
+		return migrate(tx)
 	})
 	if err != nil {
 		_ = db.Close()
@@ -52,19 +54,108 @@ type ConfigRecord struct {
 	Port      int    `json:"port"`
 	Quarantine bool  `json:"quarantine"`
 	Deleted   bool   `json:"deleted"`
+
+	// parsed node model (credentials stay in Raw)
+	Transport   string   `json:"transport,omitempty"`
+	Security    string   `json:"security,omitempty"`
+	SNI         string   `json:"sni,omitempty"`
+	Path        string   `json:"path,omitempty"`
+	HostHeader  string   `json:"host_header,omitempty"`
+	ServiceName string   `json:"service_name,omitempty"`
+	ALPN        []string `json:"alpn,omitempty"`
+	Fingerprint string   `json:"fingerprint,omitempty"`
+	Obfs        string   `json:"obfs,omitempty"`
+	Remark      string   `json:"remark,omitempty"`
+
+	SourceURL     string   `json:"source_url,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	FirstSeenUnix int64    `json:"first_seen_unix,omitempty"`
+	LastSeenUnix  int64    `json:"last_seen_unix,omitempty"`
+	Aliases       []Alias  `json:"aliases,omitempty"`
+	SchemaVersion int      `json:"schema_version"`
+}
+
+// Alias is one upstream spelling of a logical node: same server, but a
+// different remark, parameter order or encoding.
+type Alias struct {
+	Raw       string `json:"raw"`
+	Remark    string `json:"remark,omitempty"`
+	SourceURL string `json:"source_url,omitempty"`
+}
+
+// maxAliases bounds record growth for nodes re-published by many sources.
+const maxAliases = 32
+
+// AddAlias records a raw variant unless it is already known or the list is full.
+func (c *ConfigRecord) AddAlias(a Alias) bool {
+	for _, x := range c.Aliases {
+		if x.Raw == a.Raw {
+			return false
+		}
+	}
+	if len(c.Aliases) >= maxAliases {
+		return false
+	}
+	c.Aliases = append(c.Aliases, a)
+	return true
 }
 
+// ApplyNode copies the parsed fields of n onto the record.
+func (c *ConfigRecord) ApplyNode(n *uri.Node) {
+	c.Proto, c.Host, c.Port = n.Proto, n.Host, n.Port
+	c.Transport, c.Security, c.SNI = n.Transport, n.Security, n.SNI
+	c.Path, c.HostHeader, c.ServiceName = n.Path, n.HostHeader, n.ServiceName
+	c.ALPN, c.Fingerprint, c.Obfs = n.ALPN, n.Fingerprint, n.Obfs
+	c.Remark = n.Remark
+}
+
+// TLS reports whether the outer connection is TLS (or REALITY).
+func (c *ConfigRecord) TLS() bool { return c.Security != "" && c.Security != "none" }
+
 type StatsRecord struct {
-	ID                  string `json:"id"`
-	Attempts            int    `json:"attempts"`
-	Successes           int    `json:"successes"`
-	Failures            int    `json:"failures"`
-	ConsecutiveFailures int    `json:"consecutive_failures"`
-	LastSuccessUnix     int64  `json:"last_success_unix"`
-	LastFailureUnix     int64  `json:"last_failure_unix"`
+	ID                  string  `json:"id"`
+	Attempts            int     `json:"attempts"`
+	Successes           int     `json:"successes"`
+	Failures            int     `json:"failures"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	LastSuccessUnix     int64   `json:"last_success_unix"`
+	LastFailureUnix     int64   `json:"last_failure_unix"`
+	// latency of successful probes: last value and an EWMA
+	LastLatencyMS int64   `json:"last_latency_ms,omitempty"`
+	AvgLatencyMS  float64 `json:"avg_latency_ms,omitempty"`
+	// origins that succeeded / were asked in the last probe round
+	OriginsOK    int `json:"origins_ok,omitempty"`
+	OriginsTried int `json:"origins_tried,omitempty"`
+	// attempts (retries included) of the last round, and rounds that needed a retry
+	LastProbeAttempts int `json:"last_probe_attempts,omitempty"`
+	RetriedProbes     int `json:"retried_probes,omitempty"`
+	// last bandwidth measurement in bytes/sec (0 when it failed) and its time
+	ThroughputBPS  float64 `json:"throughput_bps,omitempty"`
+	ThroughputUnix int64   `json:"throughput_unix,omitempty"`
+}
+
+// ProbeOutcome is one probe round of a node across all origins.
+type ProbeOutcome struct {
+	Success      bool
+	Latency      time.Duration // average over origins; 0 when unmeasured
+	OriginsOK    int
+	OriginsTried int
+	Attempts     int // summed over origins, retries included
+}
+
+// latencyAlpha weights the newest sample in AvgLatencyMS.
+const latencyAlpha = 0.3
+
+// SuccessRate is Successes/Attempts, or 0 before the first probe.
+func (s *StatsRecord) SuccessRate() float64 {
+	if s.Attempts == 0 {
+		return 0
+	}
+	return float64(s.Successes) / float64(s.Attempts)
 }
 
 func (d *DB) PutConfig(c ConfigRecord) error {
+	c.SchemaVersion = SchemaVersion
 	return d.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucketConfigs)
 		j, _ := json.Marshal(c)
@@ -116,7 +207,7 @@ func (d *DB) GetStats(id string) (*StatsRecord, error) {
 	return &s, nil
 }
 
-func (d *DB) UpdateStatsForProbe(id string, success bool) (*StatsRecord, error) {
+func (d *DB) UpdateStatsForProbe(id string, o ProbeOutcome) (*StatsRecord, error) {
 	var s StatsRecord
 	err := d.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucketStats)
@@ -127,11 +218,25 @@ func (d *DB) UpdateStatsForProbe(id string, success bool) (*StatsRecord, error)
 			s = StatsRecord{ID: id}
 		}
 		s.Attempts++
+		s.OriginsOK, s.OriginsTried = o.OriginsOK, o.OriginsTried
+		s.LastProbeAttempts = o.Attempts
+		if o.Attempts > o.OriginsTried {
+			s.RetriedProbes++
+		}
 		now := time.Now().Unix()
-		if success {
+		if o.Success {
 			s.Successes++
 			s.LastSuccessUnix = now
 			s.ConsecutiveFailures = 0
+			if o.Latency > 0 {
+				ms := o.Latency.Milliseconds()
+				s.LastLatencyMS = ms
+				if s.AvgLatencyMS == 0 {
+					s.AvgLatencyMS = float64(ms)
+				} else {
+					s.AvgLatencyMS = latencyAlpha*float64(ms) + (1-latencyAlpha)*s.AvgLatencyMS
+				}
+			}
 		} else {
 			s.Failures++
 			s.LastFailureUnix = now
@@ -146,6 +251,54 @@ func (d *DB) UpdateStatsForProbe(id string, success bool) (*StatsRecord, error)
 	return &s, nil
 }
 
+// UpdateThroughput records a bandwidth measurement; a failed one is stored
+// as 0 so the node stops qualifying for throughput filters.
+func (d *DB) UpdateThroughput(id string, bytesPerSec float64) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketStats)
+		s := StatsRecord{ID: id}
+		if v := b.Get([]byte(id)); v != nil {
+			_ = json.Unmarshal(v, &s)
+		}
+		s.ThroughputBPS = bytesPerSec
+		s.ThroughputUnix = time.Now().Unix()
+		j, _ := json.Marshal(s)
+		return b.Put([]byte(id), j)
+	})
+}
+
+// ExportState is the last successful write of one output file.
+type ExportState struct {
+	Path        string `json:"path"`
+	Hash        string `json:"hash"` // hex sha256 of the content
+	Bytes       int    `json:"bytes"`
+	WrittenUnix int64  `json:"written_unix"`
+}
+
+func exportStateKey(path string) []byte { return []byte("export:" + path) }
+
+func (d *DB) GetExportState(path string) (*ExportState, error) {
+	var e ExportState
+	err := d.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketState).Get(exportStateKey(path))
+		if v == nil {
+			return errors.New("not_found")
+		}
+		return json.Unmarshal(v, &e)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (d *DB) PutExportState(e ExportState) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		j, _ := json.Marshal(e)
+		return tx.Bucket(bucketState).Put(exportStateKey(e.Path), j)
+	})
+}
+
 func (d *DB) SnapshotConfig(c ConfigRecord, dir string) (string, error) {
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return "", err
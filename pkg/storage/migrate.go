@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/yasi-python/go/pkg/uri"
+)
+
+// SchemaVersion is the layout written by this build. Databases created
+// before versioning existed are treated as version 1.
+const SchemaVersion = 3
+
+var keySchemaVersion = []byte("schema_version")
+
+// migrations[i] upgrades a database from version i+1 to i+2.
+var migrations = []func(tx *bolt.Tx) error{
+	migrateParsedFields,
+	migrateCanonicalIDs,
+}
+
+// migrate runs inside the Open transaction so a failed upgrade leaves the
+// file untouched.
+func migrate(tx *bolt.Tx) error {
+	st := tx.Bucket(bucketState)
+	v := 1
+	if raw := st.Get(keySchemaVersion); raw != nil {
+		n, err := strconv.Atoi(string(raw))
+		if err != nil {
+			return errors.New("bad_schema_version")
+		}
+		if n >= 1 {
+			v = n
+		}
+	}
+	for ; v < SchemaVersion; v++ {
+		if err := migrations[v-1](tx); err != nil {
+			return err
+		}
+	}
+	return st.Put(keySchemaVersion, []byte(strconv.Itoa(v)))
+}
+
+// migrateParsedFields re-parses Raw to fill the node model and seeds the
+// seen timestamps for records written by v1.
+func migrateParsedFields(tx *bolt.Tx) error {
+	b := tx.Bucket(bucketConfigs)
+	now := time.Now().Unix()
+	updates := map[string][]byte{}
+	err := b.ForEach(func(k, v []byte) error {
+		var c ConfigRecord
+		if err := json.Unmarshal(v, &c); err != nil {
+			return nil
+		}
+		if n, err := uri.Parse(c.Raw); err == nil {
+			c.ApplyNode(n)
+		}
+		if c.FirstSeenUnix == 0 {
+			c.FirstSeenUnix = now
+		}
+		if c.LastSeenUnix == 0 {
+			c.LastSeenUnix = now
+		}
+		c.SchemaVersion = 2
+		j, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		updates[string(k)] = j
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// bolt forbids mutating a bucket while iterating it
+	for k, j := range updates {
+		if err := b.Put([]byte(k), j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateCanonicalIDs rekeys records from the raw-string hash to the
+// canonical node ID, folding variants of the same server into one record
+// with aliases and summing their stats.
+func migrateCanonicalIDs(tx *bolt.Tx) error {
+	cb := tx.Bucket(bucketConfigs)
+	sb := tx.Bucket(bucketStats)
+	merged := map[string]*ConfigRecord{}
+	stats := map[string]*StatsRecord{}
+	oldKeys := [][]byte{}
+	err := cb.ForEach(func(k, v []byte) error {
+		var c ConfigRecord
+		if err := json.Unmarshal(v, &c); err != nil {
+			return nil
+		}
+		n, err := uri.Parse(c.Raw)
+		if err != nil {
+			// unparsable leftovers keep their key
+			return nil
+		}
+		oldKeys = append(oldKeys, append([]byte(nil), k...))
+		id := n.ID()
+		alias := Alias{Raw: c.Raw, Remark: c.Remark, SourceURL: c.SourceURL}
+		if m, ok := merged[id]; ok {
+			m.AddAlias(alias)
+			m.Quarantine = m.Quarantine || c.Quarantine
+			m.Deleted = m.Deleted && c.Deleted
+			if c.FirstSeenUnix != 0 && c.FirstSeenUnix < m.FirstSeenUnix {
+				m.FirstSeenUnix = c.FirstSeenUnix
+			}
+			if c.LastSeenUnix > m.LastSeenUnix {
+				m.LastSeenUnix = c.LastSeenUnix
+			}
+		} else {
+			c.ID = id
+			c.AddAlias(alias)
+			merged[id] = &c
+		}
+		if v := sb.Get(k); v != nil {
+			var s StatsRecord
+			if json.Unmarshal(v, &s) == nil {
+				stats[id] = mergeStats(stats[id], s, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range oldKeys {
+		if err := cb.Delete(k); err != nil {
+			return err
+		}
+		if err := sb.Delete(k); err != nil {
+			return err
+		}
+	}
+	for id, c := range merged {
+		c.SchemaVersion = 3
+		j, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if err := cb.Put([]byte(id), j); err != nil {
+			return err
+		}
+		if s := stats[id]; s != nil {
+			j, _ := json.Marshal(s)
+			if err := sb.Put([]byte(id), j); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func mergeStats(acc *StatsRecord, s StatsRecord, id string) *StatsRecord {
+	if acc == nil {
+		s.ID = id
+		return &s
+	}
+	acc.Attempts += s.Attempts
+	acc.Successes += s.Successes
+	acc.Failures += s.Failures
+	// the streak belongs to whichever variant was probed most recently
+	if max(s.LastSuccessUnix, s.LastFailureUnix) > max(acc.LastSuccessUnix, acc.LastFailureUnix) {
+		acc.ConsecutiveFailures = s.ConsecutiveFailures
+	}
+	acc.LastSuccessUnix = max(acc.LastSuccessUnix, s.LastSuccessUnix)
+	acc.LastFailureUnix = max(acc.LastFailureUnix, s.LastFailureUnix)
+	return acc
+}
@@ -0,0 +1,175 @@
+package uri
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// String renders n back into a share link that Parse accepts. Query keys
+// come out sorted, so equal nodes always encode identically.
+func (n *Node) String() string {
+	switch n.Proto {
+	case "vmess":
+		return n.vmessString()
+	case "ssr":
+		return n.ssrString()
+	}
+	u := url.URL{Host: net.JoinHostPort(n.Host, strconv.Itoa(n.Port)), Fragment: n.Remark}
+	q := url.Values{}
+	switch n.Proto {
+	case "vless":
+		u.Scheme, u.User = "vless", url.User(n.UUID)
+		q.Set("encryption", or(n.Cipher, "none"))
+		n.streamQuery(q)
+	case "trojan":
+		u.Scheme, u.User = "trojan", url.User(n.Password)
+		n.streamQuery(q)
+	case "ss":
+		u.Scheme = "ss"
+		if strings.HasPrefix(n.Cipher, "2022-") {
+			// SIP022: userinfo is percent-encoded plaintext
+			u.User = url.UserPassword(n.Cipher, n.Password)
+		} else {
+			u.User = url.User(base64.RawURLEncoding.EncodeToString([]byte(n.Cipher + ":" + n.Password)))
+		}
+		if n.Plugin != "" {
+			u.Path = "/"
+			q.Set("plugin", strings.TrimSuffix(n.Plugin+";"+n.PluginOpts, ";"))
+		}
+	case "hysteria2":
+		u.Scheme, u.User, u.Path = "hysteria2", url.User(n.Password), "/"
+		setIf(q, "sni", n.SNI)
+		setIf(q, "alpn", strings.Join(n.ALPN, ","))
+		setIf(q, "obfs", n.Obfs)
+		setIf(q, "obfs-password", n.ObfsParam)
+		setIf(q, "mport", n.Ports)
+		if n.AllowInsecure {
+			q.Set("insecure", "1")
+		}
+	case "tuic":
+		u.Scheme, u.User = "tuic", url.UserPassword(n.UUID, n.Password)
+		setIf(q, "sni", n.SNI)
+		setIf(q, "alpn", strings.Join(n.ALPN, ","))
+		setIf(q, "congestion_control", n.CongestionControl)
+		setIf(q, "udp_relay_mode", n.UDPRelayMode)
+		if n.AllowInsecure {
+			q.Set("allow_insecure", "1")
+		}
+	case "wireguard":
+		u.Scheme, u.User = "wireguard", url.User(n.PrivateKey)
+		setIf(q, "publickey", n.PublicKey)
+		setIf(q, "presharedkey", n.PreSharedKey)
+		setIf(q, "address", strings.Join(n.LocalAddress, ","))
+		setIf(q, "reserved", n.Reserved)
+		if n.MTU > 0 {
+			q.Set("mtu", strconv.Itoa(n.MTU))
+		}
+	case "socks5":
+		u.Scheme = "socks5"
+		if n.Username != "" || n.Password != "" {
+			u.User = url.UserPassword(n.Username, n.Password)
+		}
+	default:
+		return n.Raw
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// streamQuery is the inverse of stdLink.applyStream.
+func (n *Node) streamQuery(q url.Values) {
+	q.Set("type", or(n.Transport, "tcp"))
+	q.Set("security", or(n.Security, "none"))
+	setIf(q, "headerType", n.HeaderType)
+	switch n.Transport {
+	case "grpc":
+		setIf(q, "serviceName", n.ServiceName)
+		setIf(q, "mode", n.Mode)
+		setIf(q, "authority", n.HostHeader)
+	default:
+		setIf(q, "path", n.Path)
+		setIf(q, "host", n.HostHeader)
+	}
+	setIf(q, "sni", n.SNI)
+	setIf(q, "alpn", strings.Join(n.ALPN, ","))
+	setIf(q, "fp", n.Fingerprint)
+	setIf(q, "flow", n.Flow)
+	if n.Security == "reality" {
+		setIf(q, "pbk", n.PublicKey)
+		setIf(q, "sid", n.ShortID)
+		setIf(q, "spx", n.SpiderX)
+	}
+	if n.AllowInsecure {
+		q.Set("allowInsecure", "1")
+	}
+}
+
+func (n *Node) vmessString() string {
+	v := struct {
+		V    string `json:"v"`
+		PS   string `json:"ps"`
+		Add  string `json:"add"`
+		Port string `json:"port"`
+		ID   string `json:"id"`
+		Aid  string `json:"aid"`
+		Scy  string `json:"scy"`
+		Net  string `json:"net"`
+		Type string `json:"type"`
+		Host string `json:"host"`
+		Path string `json:"path"`
+		TLS  string `json:"tls"`
+		SNI  string `json:"sni"`
+		ALPN string `json:"alpn"`
+		FP   string `json:"fp"`
+	}{
+		V: "2", PS: n.Remark, Add: n.Host, Port: strconv.Itoa(n.Port), ID: n.UUID,
+		Aid: strconv.Itoa(n.AlterID), Scy: or(n.Cipher, "auto"), Net: or(n.Transport, "tcp"),
+		Type: or(n.HeaderType, "none"), Host: n.HostHeader, Path: n.Path,
+		SNI: n.SNI, ALPN: strings.Join(n.ALPN, ","), FP: n.Fingerprint,
+	}
+	if n.Transport == "grpc" {
+		v.Path, v.Type = n.ServiceName, or(n.Mode, "gun")
+	}
+	if n.TLS() {
+		v.TLS = n.Security
+	}
+	j, _ := json.Marshal(v)
+	return "vmess://" + base64.StdEncoding.EncodeToString(j)
+}
+
+func (n *Node) ssrString() string {
+	b64 := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	host := n.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	main := strings.Join([]string{
+		host, strconv.Itoa(n.Port), n.SSRProtocol, n.Cipher, n.Obfs, b64(n.Password),
+	}, ":")
+	q := []string{}
+	if n.ObfsParam != "" {
+		q = append(q, "obfsparam="+b64(n.ObfsParam))
+	}
+	if n.SSRProtocolParam != "" {
+		q = append(q, "protoparam="+b64(n.SSRProtocolParam))
+	}
+	q = append(q, "remarks="+b64(n.Remark))
+	return "ssr://" + b64(main+"/?"+strings.Join(q, "&"))
+}
+
+func setIf(q url.Values, k, v string) {
+	if v != "" {
+		q.Set(k, v)
+	}
+}
+
+func or(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,56 @@
+package uri
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// Identity is the canonical fingerprint of the server behind a link:
+// proto, address, credential, transport, path, SNI and, for the HTTP-based
+// transports, the Host header (CDN fronts route on it). Remarks, parameter
+// order and vmess JSON encoding do not affect it.
+func (n *Node) Identity() string {
+	host := strings.TrimSuffix(strings.ToLower(n.Host), ".")
+	path := n.Path
+	if n.Transport == "grpc" {
+		path = n.ServiceName
+	}
+	sni := ""
+	if n.TLS() {
+		sni = strings.ToLower(n.ServerName())
+	}
+	hostHeader := ""
+	switch n.Transport {
+	case "ws", "httpupgrade", "h2":
+		hostHeader = strings.TrimSuffix(strings.ToLower(n.HostHeader), ".")
+	}
+	return strings.Join([]string{
+		n.Proto, host, strconv.Itoa(n.Port), n.credential(), n.Transport, path, sni, hostHeader,
+	}, "|")
+}
+
+// ID is the storage key derived from Identity.
+func (n *Node) ID() string {
+	h := sha1.Sum([]byte(n.Identity()))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func (n *Node) credential() string {
+	switch n.Proto {
+	case "vmess", "vless":
+		return strings.ToLower(n.UUID)
+	case "tuic":
+		return strings.ToLower(n.UUID) + ":" + n.Password
+	case "ss":
+		return n.Cipher + ":" + n.Password
+	case "ssr":
+		return n.Cipher + ":" + n.Password + ":" + n.SSRProtocol + ":" + n.Obfs
+	case "socks5":
+		return n.Username + ":" + n.Password
+	case "wireguard":
+		return n.PrivateKey + ":" + n.PublicKey
+	}
+	return n.Password
+}
@@ -0,0 +1,21 @@
+package uri
+
+import "strings"
+
+// parseSocks handles socks5://[user:pass@]host:port#tag; some generators put
+// base64(user:pass) in the userinfo.
+func parseSocks(s string) (*Node, error) {
+	l, err := parseStdLink(s)
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{Proto: "socks5", Host: l.Host, Port: l.Port, Remark: l.Remark, Transport: "tcp", Security: "none"}
+	user := l.User
+	if user != "" && !strings.Contains(user, ":") {
+		if dec, err := decodeBase64(user); err == nil && strings.Contains(string(dec), ":") {
+			user = string(dec)
+		}
+	}
+	n.Username, n.Password, _ = strings.Cut(user, ":")
+	return n, nil
+}
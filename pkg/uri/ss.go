@@ -0,0 +1,131 @@
+package uri
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ssCiphers lists the AEAD methods we keep. Stream ciphers are rejected as
+// insecure: they are trivially probed by censors and most servers dropped them.
+var ssCiphers = map[string]bool{
+	"aes-128-gcm":                   true,
+	"aes-192-gcm":                   true,
+	"aes-256-gcm":                   true,
+	"chacha20-ietf-poly1305":        true,
+	"xchacha20-ietf-poly1305":       true,
+	"2022-blake3-aes-128-gcm":       true,
+	"2022-blake3-aes-256-gcm":       true,
+	"2022-blake3-chacha20-poly1305": true,
+}
+
+var ssInsecure = map[string]bool{
+	"none": true, "plain": true, "table": true, "rc4": true, "rc4-md5": true,
+	"aes-128-cfb": true, "aes-192-cfb": true, "aes-256-cfb": true,
+	"aes-128-ctr": true, "aes-192-ctr": true, "aes-256-ctr": true,
+	"bf-cfb": true, "camellia-128-cfb": true, "camellia-192-cfb": true, "camellia-256-cfb": true,
+	"chacha20": true, "chacha20-ietf": true, "salsa20": true,
+}
+
+// CipherError is returned for shadowsocks methods we refuse to store.
+type CipherError struct {
+	Cipher   string
+	Insecure bool
+}
+
+func (e *CipherError) Error() string {
+	if e.Insecure {
+		return "insecure_cipher:" + e.Cipher
+	}
+	return "unsupported_cipher:" + e.Cipher
+}
+
+func checkSSCipher(c string) error {
+	if ssCiphers[c] {
+		return nil
+	}
+	return &CipherError{Cipher: c, Insecure: ssInsecure[c]}
+}
+
+// parseSS handles SIP002 (ss://userinfo@host:port/?plugin=..#tag, with
+// userinfo either base64url or percent-encoded) and the legacy
+// ss://base64(method:password@host:port)#tag form.
+func parseSS(body string) (*Node, error) {
+	remark := ""
+	if i := strings.IndexByte(body, '#'); i >= 0 {
+		remark = unescapeLoose(body[i+1:])
+		body = body[:i]
+	}
+	query := ""
+	if i := strings.IndexByte(body, '?'); i >= 0 {
+		query = body[i+1:]
+		body = body[:i]
+	}
+	body = strings.TrimSuffix(body, "/")
+
+	var userinfo, hostport string
+	if at := strings.LastIndexByte(body, '@'); at >= 0 {
+		userinfo, hostport = body[:at], body[at+1:]
+		if u, err := url.PathUnescape(userinfo); err == nil && strings.Contains(u, ":") {
+			userinfo = u
+		} else {
+			dec, err := decodeBase64(userinfo)
+			if err != nil {
+				return nil, ErrMalformed
+			}
+			userinfo = string(dec)
+		}
+	} else {
+		dec, err := decodeBase64(body)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		at := strings.LastIndexByte(string(dec), '@')
+		if at < 0 {
+			return nil, ErrMalformed
+		}
+		userinfo, hostport = string(dec[:at]), string(dec[at+1:])
+	}
+
+	method, password, ok := strings.Cut(userinfo, ":")
+	if !ok || method == "" {
+		return nil, ErrMalformed
+	}
+	host, ps, err := net.SplitHostPort(strings.TrimSpace(hostport))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	port, err := strconv.Atoi(ps)
+	if err != nil {
+		return nil, ErrInvalidPort
+	}
+	n := &Node{
+		Proto: "ss", Host: host, Port: port, Transport: "tcp", Security: "none",
+		Cipher: strings.ToLower(method), Password: password, Remark: remark,
+	}
+	if err := checkSSCipher(n.Cipher); err != nil {
+		return nil, err
+	}
+	if query != "" {
+		q, _ := url.ParseQuery(query)
+		if p := q.Get("plugin"); p != "" {
+			n.Plugin, n.PluginOpts, _ = strings.Cut(p, ";")
+			if n.Plugin == "simple-obfs" {
+				n.Plugin = "obfs-local"
+			}
+		}
+	}
+	return n, nil
+}
+
+// PluginOpt returns a single key from the ;-separated plugin options.
+func (n *Node) PluginOpt(key string) string {
+	for _, kv := range strings.Split(n.PluginOpts, ";") {
+		k, v, _ := strings.Cut(kv, "=")
+		if k == key {
+			return v
+		}
+	}
+	return ""
+}
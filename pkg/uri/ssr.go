@@ -0,0 +1,52 @@
+package uri
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// parseSSR handles ssr://base64(host:port:protocol:method:obfs:base64(pass)/?obfsparam=&protoparam=&remarks=&group=)
+// where every parameter value is itself base64url.
+func parseSSR(body string) (*Node, error) {
+	dec, err := decodeBase64(body)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	main, query, _ := strings.Cut(string(dec), "/?")
+	main = strings.TrimSuffix(main, "/")
+	// host may be an IPv6 literal, so count fields from the right
+	parts := strings.Split(main, ":")
+	if len(parts) < 6 {
+		return nil, ErrMalformed
+	}
+	k := len(parts) - 5
+	host := strings.Trim(strings.Join(parts[:k], ":"), "[]")
+	port, err := strconv.Atoi(parts[k])
+	if err != nil {
+		return nil, ErrInvalidPort
+	}
+	pass, err := decodeBase64(parts[k+4])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	n := &Node{
+		Proto: "ssr", Host: host, Port: port, Transport: "tcp", Security: "none",
+		SSRProtocol: parts[k+1], Cipher: strings.ToLower(parts[k+2]), Obfs: parts[k+3], Password: string(pass),
+	}
+	q, _ := url.ParseQuery(query)
+	b64 := func(key string) string {
+		v := q.Get(key)
+		if v == "" {
+			return ""
+		}
+		if d, err := decodeBase64(v); err == nil {
+			return string(d)
+		}
+		return v
+	}
+	n.ObfsParam = b64("obfsparam")
+	n.SSRProtocolParam = b64("protoparam")
+	n.Remark = b64("remarks")
+	return n, nil
+}
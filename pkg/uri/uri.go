@@ -0,0 +1,187 @@
+package uri
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrUnsupportedScheme = errors.New("unsupported_scheme")
+	ErrMalformed         = errors.New("malformed_uri")
+	ErrMissingHost       = errors.New("missing_host")
+	ErrInvalidPort       = errors.New("invalid_port")
+)
+
+// Node is the typed form of a share link. Fields that do not apply to a
+// protocol are left zero.
+type Node struct {
+	Proto string
+	Host  string
+	Port  int
+
+	// credentials
+	UUID     string
+	AlterID  int
+	Cipher   string
+	Username string
+	Password string
+	Flow     string
+
+	// shadowsocks plugin (obfs-local, v2ray-plugin) and its ;-separated opts
+	Plugin     string
+	PluginOpts string
+
+	// ssr protocol/obfs, hysteria2 obfs (salamander)
+	SSRProtocol      string
+	SSRProtocolParam string
+	Obfs             string
+	ObfsParam        string
+
+	// QUIC family (hysteria2, tuic); Ports is a port-hopping spec like "443,5000-6000"
+	Ports             string
+	CongestionControl string
+	UDPRelayMode      string
+
+	// wireguard; the peer key is kept in PublicKey
+	PrivateKey   string
+	PreSharedKey string
+	LocalAddress []string
+	MTU          int
+	Reserved     string
+
+	// transport: tcp|ws|grpc|h2|httpupgrade|kcp|quic|udp
+	Transport   string
+	Path        string
+	HostHeader  string
+	HeaderType  string
+	ServiceName string
+	Mode        string
+
+	// security: none|tls|reality
+	Security      string
+	SNI           string
+	ALPN          []string
+	Fingerprint   string
+	AllowInsecure bool
+	PublicKey     string
+	ShortID       string
+	SpiderX       string
+
+	Remark string
+	Raw    string
+}
+
+// UDP reports whether the node is reached over UDP (QUIC or WireGuard).
+func (n *Node) UDP() bool { return n.Transport == "quic" || n.Transport == "udp" }
+
+// TLS reports whether the node speaks TLS on its outer connection.
+func (n *Node) TLS() bool { return n.Security != "" && n.Security != "none" }
+
+// ServerName is the name to send in the TLS ClientHello.
+func (n *Node) ServerName() string {
+	if n.SNI != "" {
+		return n.SNI
+	}
+	if n.HostHeader != "" {
+		return n.HostHeader
+	}
+	return n.Host
+}
+
+// Parse dispatches on the scheme of raw and returns the typed node.
+func Parse(raw string) (*Node, error) {
+	s := strings.TrimSpace(raw)
+	i := strings.Index(s, "://")
+	if i <= 0 {
+		return nil, ErrMalformed
+	}
+	var (
+		n   *Node
+		err error
+	)
+	switch Scheme(s) {
+	case "vmess":
+		n, err = parseVMess(s[i+3:])
+	case "vless":
+		n, err = parseVLESS(s)
+	case "trojan":
+		n, err = parseTrojan(s)
+	case "ss":
+		n, err = parseSS(s[i+3:])
+	case "ssr":
+		n, err = parseSSR(s[i+3:])
+	case "hysteria2", "hy2":
+		n, err = parseHysteria2(s)
+	case "tuic":
+		n, err = parseTUIC(s)
+	case "wireguard", "wg":
+		n, err = parseWireGuard(s)
+	case "socks", "socks5":
+		n, err = parseSocks(s)
+	default:
+		return nil, ErrUnsupportedScheme
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n.Host == "" {
+		return nil, ErrMissingHost
+	}
+	if n.Port <= 0 || n.Port > 65535 {
+		return nil, ErrInvalidPort
+	}
+	n.Raw = s
+	return n, nil
+}
+
+// Scheme returns the lower-cased scheme of raw, or "" if it has none.
+func Scheme(raw string) string {
+	s := strings.TrimSpace(raw)
+	if i := strings.Index(s, "://"); i > 0 {
+		return strings.ToLower(s[:i])
+	}
+	return ""
+}
+
+// decodeBase64 accepts std and url alphabets, with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func normTransport(t string) string {
+	switch t = strings.ToLower(strings.TrimSpace(t)); t {
+	case "", "raw", "tcp":
+		return "tcp"
+	case "http", "h2":
+		return "h2"
+	case "gun":
+		return "grpc"
+	}
+	return t
+}
+
+func normSecurity(s string) string {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "", "none", "false", "0":
+		return "none"
+	case "true", "1", "xtls":
+		return "tls"
+	}
+	return s
+}
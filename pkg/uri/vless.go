@@ -0,0 +1,148 @@
+package uri
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// stdLink is the RFC 3986 shape shared by vless, trojan and most newer
+// schemes: scheme://user@host:port?query#remark
+type stdLink struct {
+	User   string
+	Host   string
+	Port   int
+	Query  url.Values
+	Remark string
+}
+
+func parseStdLink(s string) (*stdLink, error) {
+	// remarks are frequently left unescaped (spaces, emoji, stray '%'),
+	// so split the fragment off before handing the rest to net/url.
+	remark := ""
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		remark = unescapeLoose(s[i+1:])
+		s = s[:i]
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if u.Host == "" {
+		return nil, ErrMissingHost
+	}
+	l := &stdLink{Remark: remark, Host: u.Hostname()}
+	if u.User != nil {
+		l.User = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			l.User += ":" + p
+		}
+	}
+	if _, ps, err := net.SplitHostPort(u.Host); err == nil {
+		l.Port, err = strconv.Atoi(ps)
+		if err != nil {
+			return nil, ErrInvalidPort
+		}
+	}
+	l.Query, _ = url.ParseQuery(u.RawQuery)
+	return l, nil
+}
+
+func unescapeLoose(s string) string {
+	if u, err := url.PathUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// first returns the first non-empty query value among keys.
+func (l *stdLink) first(keys ...string) string {
+	for _, k := range keys {
+		if v := strings.TrimSpace(l.Query.Get(k)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (l *stdLink) flag(keys ...string) bool {
+	switch strings.ToLower(l.first(keys...)) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// applyStream fills the transport and security options shared by the
+// Xray-style query format (vless, trojan).
+func (l *stdLink) applyStream(n *Node, defaultSecurity string) {
+	n.Transport = normTransport(l.first("type", "net", "network"))
+	n.HeaderType = l.first("headerType")
+	if n.HeaderType == "none" {
+		n.HeaderType = ""
+	}
+	switch n.Transport {
+	case "grpc":
+		n.ServiceName = l.first("serviceName", "path")
+		n.Mode = l.first("mode")
+		n.HostHeader = l.first("authority", "host")
+	case "ws", "httpupgrade", "h2":
+		n.Path = l.first("path")
+		n.HostHeader = l.first("host")
+		if n.Path == "" {
+			n.Path = "/"
+		} else if !strings.HasPrefix(n.Path, "/") {
+			n.Path = "/" + n.Path
+		}
+	default:
+		n.HostHeader = l.first("host")
+		n.Path = l.first("path")
+	}
+	sec := l.first("security")
+	if sec == "" {
+		sec = defaultSecurity
+	}
+	n.Security = normSecurity(sec)
+	n.SNI = l.first("sni", "peer", "serverName")
+	n.ALPN = splitList(l.first("alpn"))
+	n.Fingerprint = l.first("fp", "fingerprint")
+	n.AllowInsecure = l.flag("allowInsecure", "insecure", "skip-cert-verify")
+	if n.Security == "reality" {
+		n.PublicKey = l.first("pbk", "publicKey")
+		n.ShortID = l.first("sid", "shortId")
+		n.SpiderX = l.first("spx", "spiderX")
+	}
+	n.Flow = l.first("flow")
+}
+
+func parseVLESS(s string) (*Node, error) {
+	l, err := parseStdLink(s)
+	if err != nil {
+		return nil, err
+	}
+	if l.User == "" {
+		return nil, ErrMalformed
+	}
+	n := &Node{Proto: "vless", Host: l.Host, Port: l.Port, UUID: l.User, Remark: l.Remark}
+	n.Cipher = l.first("encryption")
+	if n.Cipher == "" {
+		n.Cipher = "none"
+	}
+	l.applyStream(n, "none")
+	return n, nil
+}
+
+func parseTrojan(s string) (*Node, error) {
+	l, err := parseStdLink(s)
+	if err != nil {
+		return nil, err
+	}
+	if l.User == "" {
+		return nil, ErrMalformed
+	}
+	n := &Node{Proto: "trojan", Host: l.Host, Port: l.Port, Password: l.User, Remark: l.Remark}
+	// trojan is TLS unless the link says otherwise
+	l.applyStream(n, "tls")
+	return n, nil
+}
@@ -0,0 +1,98 @@
+package uri
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// vmessJSON is the v2 share format (v2rayN). Numeric fields are emitted as
+// either strings or numbers depending on the generator.
+type vmessJSON struct {
+	V    flexString `json:"v"`
+	PS   string     `json:"ps"`
+	Add  string     `json:"add"`
+	Port flexString `json:"port"`
+	ID   string     `json:"id"`
+	Aid  flexString `json:"aid"`
+	Scy  string     `json:"scy"`
+	Net  string     `json:"net"`
+	Type string     `json:"type"`
+	Host string     `json:"host"`
+	Path string     `json:"path"`
+	TLS  flexString `json:"tls"`
+	SNI  string     `json:"sni"`
+	ALPN string     `json:"alpn"`
+	FP   string     `json:"fp"`
+}
+
+type flexString string
+
+func (f *flexString) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*f = flexString(s)
+		return nil
+	}
+	if string(b) == "null" {
+		*f = ""
+		return nil
+	}
+	*f = flexString(strings.TrimSpace(string(b)))
+	return nil
+}
+
+func parseVMess(body string) (*Node, error) {
+	if i := strings.IndexAny(body, "#?"); i >= 0 {
+		body = body[:i]
+	}
+	dec, err := decodeBase64(body)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var v vmessJSON
+	if err := json.Unmarshal(dec, &v); err != nil {
+		return nil, ErrMalformed
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(string(v.Port)))
+	if err != nil {
+		return nil, ErrInvalidPort
+	}
+	aid, _ := strconv.Atoi(strings.TrimSpace(string(v.Aid)))
+	n := &Node{
+		Proto:       "vmess",
+		Host:        strings.Trim(strings.TrimSpace(v.Add), "[]"),
+		Port:        port,
+		UUID:        strings.TrimSpace(v.ID),
+		AlterID:     aid,
+		Cipher:      v.Scy,
+		Transport:   normTransport(v.Net),
+		Path:        v.Path,
+		HostHeader:  v.Host,
+		Security:    normSecurity(string(v.TLS)),
+		SNI:         v.SNI,
+		ALPN:        splitList(v.ALPN),
+		Fingerprint: v.FP,
+		Remark:      v.PS,
+	}
+	if n.Cipher == "" {
+		n.Cipher = "auto"
+	}
+	// "type" is the header obfuscation for tcp/kcp/quic and the mode for grpc.
+	if v.Type != "" && v.Type != "none" && v.Type != "auto" {
+		n.HeaderType = v.Type
+	}
+	if n.Transport == "grpc" {
+		n.ServiceName, n.Path = n.Path, ""
+		n.Mode, n.HeaderType = n.HeaderType, ""
+	}
+	if n.Transport == "ws" || n.Transport == "httpupgrade" || n.Transport == "h2" {
+		if n.Path != "" && !strings.HasPrefix(n.Path, "/") {
+			n.Path = "/" + n.Path
+		}
+	}
+	return n, nil
+}
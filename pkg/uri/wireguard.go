@@ -0,0 +1,29 @@
+package uri
+
+import "strconv"
+
+// parseWireGuard handles wireguard:// and wg://
+// (privatekey@host:port?publickey=&presharedkey=&address=&mtu=&reserved=#tag).
+func parseWireGuard(s string) (*Node, error) {
+	l, err := parseStdLink(s)
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		Proto: "wireguard", Host: l.Host, Port: l.Port, Remark: l.Remark,
+		Transport: "udp", Security: "none",
+		PrivateKey:   l.User,
+		PublicKey:    l.first("publickey", "publicKey", "peer_public_key"),
+		PreSharedKey: l.first("presharedkey", "preSharedKey", "pre_shared_key"),
+		LocalAddress: splitList(l.first("address", "ip")),
+		Reserved:     l.first("reserved"),
+	}
+	if n.PrivateKey == "" {
+		n.PrivateKey = l.first("privatekey", "privateKey", "private_key")
+	}
+	if n.PrivateKey == "" || n.PublicKey == "" {
+		return nil, ErrMalformed
+	}
+	n.MTU, _ = strconv.Atoi(l.first("mtu"))
+	return n, nil
+}
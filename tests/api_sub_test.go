@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yasi-python/go/pkg/api"
+	"github.com/yasi-python/go/pkg/export"
+)
+
+type subMgr struct{ es []export.Entry }
+
+func (subMgr) ListConfigs() any        { return nil }
+func (subMgr) Reprobe(string) error    { return nil }
+func (subMgr) Quarantine(string) error { return nil }
+func (subMgr) Delete(string) error     { return nil }
+func (subMgr) Rollback(string) error   { return nil }
+func (subMgr) Health() api.Health      { return api.Health{Output: "degraded_top_n", Degraded: true} }
+func (m subMgr) Subscription(p string) (*api.Subscription, error) {
+	if p != "default" {
+		return nil, api.ErrUnknownProfile
+	}
+	return &api.Subscription{Entries: m.es, UpdateIntervalHours: 12, Userinfo: "upload=0; download=0; total=0; expire=0"}, nil
+}
+
+func TestSubscriptionEndpoint(t *testing.T) {
+	es := mustEntries(t, map[string]float64{
+		"trojan://pw@t.example.com:443?sni=t.example.com#tr": 50,
+		"ss://YWVzLTEyOC1nY206cHc@1.2.3.4:8388#ss":           80,
+	})
+	srv := api.New(subMgr{es}, "/metrics", "/healthz")
+	srv.SubToken = "s3cret"
+	h := srv.Handler()
+	get := func(path, ua string, hdr map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("User-Agent", ua)
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/healthz", "", nil); rec.Code != 200 || !strings.Contains(rec.Body.String(), `"output":"degraded_top_n"`) {
+		t.Fatalf("healthz: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := get("/sub/default", "", nil); rec.Code != 401 {
+		t.Fatalf("missing token: got %d", rec.Code)
+	}
+	if rec := get("/sub/nope?token=s3cret", "", nil); rec.Code != 404 {
+		t.Fatalf("unknown profile: got %d", rec.Code)
+	}
+
+	rec := get("/sub/default?token=s3cret", "v2rayNG/1.8", nil)
+	body, err := base64.StdEncoding.DecodeString(rec.Body.String())
+	if rec.Code != 200 || err != nil || !strings.HasPrefix(string(body), "trojan://") {
+		t.Fatalf("base64 default: %d %v %q", rec.Code, err, rec.Body.String())
+	}
+	if rec.Header().Get("profile-update-interval") != "12" || rec.Header().Get("subscription-userinfo") == "" {
+		t.Fatalf("missing refresh headers: %v", rec.Header())
+	}
+	etag, lm := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if etag == "" || lm == "" {
+		t.Fatalf("missing validators: %v", rec.Header())
+	}
+	if rec := get("/sub/default?token=s3cret", "v2rayNG/1.8", map[string]string{"If-None-Match": etag}); rec.Code != 304 {
+		t.Fatalf("If-None-Match: got %d", rec.Code)
+	}
+	if rec := get("/sub/default?token=s3cret", "v2rayNG/1.8", map[string]string{"If-Modified-Since": lm}); rec.Code != 304 {
+		t.Fatalf("If-Modified-Since: got %d", rec.Code)
+	}
+
+	if rec := get("/sub/default?token=s3cret", "clash.meta/1.18", nil); !strings.Contains(rec.Body.String(), "proxy-groups:") {
+		t.Fatalf("clash UA not sniffed: %s", rec.Body.String())
+	}
+	if rec := get("/sub/default?token=s3cret", "SFA/1.9", nil); !strings.Contains(rec.Body.String(), `"urltest"`) {
+		t.Fatalf("sing-box UA not sniffed: %s", rec.Body.String())
+	}
+	rec = get("/sub/default?token=s3cret&format=plain", "clash.meta/1.18", nil)
+	if rec.Header().Get("ETag") == etag || !strings.HasSuffix(rec.Body.String(), "#ss\n") {
+		t.Fatalf("format query should win over UA: %q", rec.Body.String())
+	}
+	if rec := get("/sub/default?token=s3cret&format=bogus", "", nil); rec.Code != 400 {
+		t.Fatalf("bogus format: got %d", rec.Code)
+	}
+}
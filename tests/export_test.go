@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/yasi-python/go/internal/subscription"
+	"github.com/yasi-python/go/pkg/export"
+	"github.com/yasi-python/go/pkg/uri"
+)
+
+func mustEntries(t *testing.T, links map[string]float64) []export.Entry {
+	t.Helper()
+	es := []export.Entry{}
+	for l, lat := range links {
+		n, err := uri.Parse(l)
+		if err != nil {
+			t.Fatalf("parse %s: %v", l, err)
+		}
+		es = append(es, export.Entry{Node: n, LatencyMS: lat, SuccessRate: 1})
+	}
+	export.SortByLatency(es)
+	return es
+}
+
+func TestExportClashProfile(t *testing.T) {
+	es := mustEntries(t, map[string]float64{
+		"trojan://pw@t.example.com:443?sni=t.example.com&type=ws&path=%2Fws&host=h.example.com#same": 120,
+		"vless://u@r.example.com:443?security=reality&pbk=k&sid=ab&sni=www.apple.com&fp=chrome#same": 40,
+		"hy2://pw@hy.example.com:443/?obfs=salamander&obfs-password=op#hy":                           0,
+		"ss://YWVzLTEyOC1nY206cHc@1.2.3.4:8388#ss":                                                   80,
+	})
+	b, err := export.Clash(es, export.ClashOptions{Rules: []string{"GEOIP,private,DIRECT", "MATCH,{{group}}"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Proxies []struct {
+			Name string `yaml:"name"`
+		} `yaml:"proxies"`
+		ProxyGroups []struct {
+			Name    string   `yaml:"name"`
+			Type    string   `yaml:"type"`
+			Proxies []string `yaml:"proxies"`
+		} `yaml:"proxy-groups"`
+		Rules []string `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("profile is not yaml: %v\n%s", err, b)
+	}
+	if len(doc.Proxies) != 4 || doc.Proxies[0].Name != "same" || doc.Proxies[2].Name != "same #2" || doc.Proxies[3].Name != "hy" {
+		t.Fatalf("proxies not sorted by latency / unique: %+v", doc.Proxies)
+	}
+	if len(doc.ProxyGroups) != 3 || doc.ProxyGroups[1].Type != "url-test" || doc.ProxyGroups[2].Type != "fallback" ||
+		doc.ProxyGroups[1].Proxies[0] != "same" {
+		t.Fatalf("bad groups: %+v", doc.ProxyGroups)
+	}
+	if strings.Join(doc.Rules, "|") != "GEOIP,private,DIRECT|MATCH,PROXY" {
+		t.Fatalf("bad rules: %v", doc.Rules)
+	}
+
+	// the profile must ingest back into the same logical nodes
+	links, _, skipped, err := subscription.Decode(string(b))
+	if err != nil || skipped != 0 || len(links) != len(es) {
+		t.Fatalf("re-ingest: %d links, %d skipped, %v", len(links), skipped, err)
+	}
+	for i, l := range links {
+		n, _ := uri.Parse(l)
+		if n.ID() != es[i].Node.ID() {
+			t.Fatalf("node %d changed through clash:\n%s\n%s", i, n.Identity(), es[i].Node.Identity())
+		}
+	}
+}
+
+// TestExportClashServerName checks that a TLS node without sni gets the
+// Host header as servername, as the sing-box and Xray renderers do.
+func TestExportClashServerName(t *testing.T) {
+	es := mustEntries(t, map[string]float64{
+		"vless://u@1.2.3.4:443?security=tls&type=ws&path=%2Fws&host=cdn.example.com#v": 10,
+	})
+	b, err := export.Clash(es, export.ClashOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Proxies []struct {
+			ServerName string `yaml:"servername"`
+		} `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil || len(doc.Proxies) != 1 || doc.Proxies[0].ServerName != "cdn.example.com" {
+		t.Fatalf("servername not taken from the host header: %v\n%s", err, b)
+	}
+}
+
+func TestExportProfileSelect(t *testing.T) {
+	es := mustEntries(t, map[string]float64{
+		"trojan://pw@t.example.com:443?sni=t.example.com&type=ws&path=%2Fws#%F0%9F%87%A9%F0%9F%87%AA%20tr":          120,
+		"vless://u@r.example.com:443?security=reality&pbk=k&sid=ab&sni=www.apple.com#%F0%9F%87%B3%F0%9F%87%B1%20vl": 40,
+		"hy2://pw@hy.example.com:443/#hy":          90,
+		"ss://YWVzLTEyOC1nY206cHc@1.2.3.4:8388#ss": 0,
+	})
+	for i := range es {
+		es[i].Country = export.Country(es[i].Node.Remark)
+		es[i].OriginsOK = 1
+		if es[i].Node.Proto == "trojan" {
+			es[i].SuccessRate, es[i].OriginsOK, es[i].Tags = 0.5, 2, []string{"paid"}
+			es[i].BytesPerSec = 2e6
+		}
+		if es[i].Node.Proto == "hysteria2" {
+			es[i].BytesPerSec = 5e5
+		}
+	}
+	names := func(es []export.Entry) string {
+		out := []string{}
+		for _, e := range es {
+			out = append(out, e.Node.Proto)
+		}
+		return strings.Join(out, ",")
+	}
+	cases := []struct {
+		f     export.Filter
+		sort  string
+		limit int
+		want  string
+	}{
+		{export.Filter{}, "", 0, "vless,hysteria2,trojan,ss"},
+		{export.Filter{TLSOnly: true}, "", 0, "vless,hysteria2,trojan"},
+		{export.Filter{UDPOnly: true}, "", 0, "hysteria2"},
+		{export.Filter{MaxLatencyMS: 100}, "", 0, "vless,hysteria2"},
+		{export.Filter{MinSuccessRate: 0.9}, "", 0, "vless,hysteria2,ss"},
+		{export.Filter{Countries: []string{"de"}}, "", 0, "trojan"},
+		{export.Filter{Transports: []string{"ws", "quic"}}, "", 0, "hysteria2,trojan"},
+		{export.Filter{MinOrigins: 2}, "", 0, "trojan"},
+		{export.Filter{Tags: []string{"paid"}}, "", 0, "trojan"},
+		{export.Filter{Protocols: []string{"ss", "vless"}}, "", 1, "vless"},
+		{export.Filter{MinBytesPerSec: 1e6}, "", 0, "trojan"},
+		{export.Filter{}, export.SortSuccessRate, 0, "vless,hysteria2,ss,trojan"},
+		{export.Filter{}, export.SortName, 0, "hysteria2,ss,trojan,vless"},
+		{export.Filter{}, export.SortThroughput, 0, "trojan,hysteria2,vless,ss"},
+	}
+	for i, c := range cases {
+		if got := names(export.Select(es, c.f, c.sort, c.limit)); got != c.want {
+			t.Errorf("case %d: got %s, want %s", i, got, c.want)
+		}
+	}
+	if got := names(es); got != "vless,hysteria2,trojan,ss" {
+		t.Fatalf("Select modified its input: %s", got)
+	}
+}
+
+func TestWriteAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out", "sub.txt")
+	for _, body := range []string{"first\n", "second\n"} {
+		if err := export.WriteAtomic(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil || string(b) != body {
+			t.Fatalf("got %q, %v", b, err)
+		}
+	}
+	left, _ := os.ReadDir(filepath.Dir(path))
+	if len(left) != 1 {
+		t.Fatalf("temp files left behind: %v", left)
+	}
+	// a directory in the way must fail without touching it
+	if err := export.WriteAtomic(filepath.Dir(path), []byte("x"), 0o644); err == nil {
+		t.Fatal("expected error replacing a directory")
+	}
+	if left, _ := os.ReadDir(dir); len(left) != 1 {
+		t.Fatalf("temp file not cleaned up after failure: %v", left)
+	}
+}
+
+func TestExportRenameRemarks(t *testing.T) {
+	vm := (&uri.Node{Proto: "vmess", Host: "vm.example.com", Port: 443, UUID: "b31c7b3e-6d4e-4b5f-9c3a-1d2e3f4a5b6c",
+		Transport: "ws", Path: "/ray", Security: "tls", Remark: "\U0001F1E9\U0001F1EA RELAY-198.41.203.3-0898"}).String()
+	es := mustEntries(t, map[string]float64{
+		vm: 42.4,
+		"trojan://pw@t.example.com:443?sni=t.example.com&type=ws&path=%2Fws#%F0%9F%87%A9%F0%9F%87%AA%20x": 99.6,
+		"trojan://pw@u.example.com:443?sni=u.example.com&type=ws&path=%2Fws#%F0%9F%87%A9%F0%9F%87%AA%20y": 99.6,
+		"ss://YWVzLTEyOC1nY206cHc@1.2.3.4:8388#noflag":                                                    0,
+	})
+	for i := range es {
+		es[i].Country = export.Country(es[i].Node.Remark)
+	}
+	out := export.Rename(es, "{{flag}} {{country}} {{proto}}-{{net}} {{latency_ms}}ms")
+	want := []string{
+		"\U0001F1E9\U0001F1EA DE vmess-ws 42ms",
+		"\U0001F1E9\U0001F1EA DE trojan-ws 100ms",
+		"\U0001F1E9\U0001F1EA DE trojan-ws 100ms #2",
+		"ss-tcp ?ms",
+	}
+	for i, e := range out {
+		if e.Node.Remark != want[i] {
+			t.Errorf("%d: remark %q, want %q", i, e.Node.Remark, want[i])
+		}
+		// the remark must travel inside the link itself
+		n, err := uri.Parse(e.Node.Raw)
+		if err != nil || n.Remark != want[i] || n.ID() != es[i].Node.ID() {
+			t.Errorf("%d: re-encoded link %q lost the remark or changed identity: %v", i, e.Node.Raw, err)
+		}
+	}
+	if !strings.HasPrefix(out[0].Node.Raw, "vmess://") || !strings.Contains(out[1].Node.Raw, "#%F0%9F%87%A9") {
+		t.Fatalf("unexpected encodings: %q %q", out[0].Node.Raw, out[1].Node.Raw)
+	}
+	if es[0].Node.Remark == out[0].Node.Remark {
+		t.Fatal("Rename modified its input")
+	}
+}
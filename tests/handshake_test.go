@@ -0,0 +1,372 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"lukechampine.com/blake3"
+
+	"github.com/yasi-python/go/pkg/probe"
+)
+
+// handshakeTarget is the site the stand-in servers relay to; it also
+// serves /echo for the content check and /blob for throughput.
+func handshakeTarget(t *testing.T) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/generate_204":
+			w.WriteHeader(http.StatusNoContent)
+		case "/echo":
+			_, _ = w.Write([]byte("hello probe"))
+		case "/blob":
+			_, _ = w.Write(make([]byte, 1<<20))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/generate_204"
+}
+
+func listen(t *testing.T, serve func(net.Conn)) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+				serve(c)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// readSocksAddr parses a SOCKS5-style address off the front of b.
+func readSocksAddr(b []byte) (string, []byte) {
+	var host string
+	switch b[0] {
+	case 1:
+		host, b = net.IP(b[1:5]).String(), b[5:]
+	case 3:
+		host, b = string(b[2:2+int(b[1])]), b[2+int(b[1]):]
+	case 4:
+		host, b = net.IP(b[1:17]).String(), b[17:]
+	}
+	return net.JoinHostPort(host, fmt.Sprint(binary.BigEndian.Uint16(b))), b[2:]
+}
+
+// fetchTarget sends the relayed request to addr and returns the full answer.
+func fetchTarget(addr string, req []byte) []byte {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil
+	}
+	defer c.Close()
+	_, _ = c.Write(req)
+	resp, _ := io.ReadAll(c)
+	return resp
+}
+
+type ssTestMethod struct {
+	keySize int
+	aead    func([]byte) (cipher.AEAD, error)
+	v2022   bool
+}
+
+func testGCM(k []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+var ssTestMethods = map[string]ssTestMethod{
+	"aes-128-gcm":                   {16, testGCM, false},
+	"chacha20-ietf-poly1305":        {32, chacha20poly1305.New, false},
+	"2022-blake3-aes-256-gcm":       {32, testGCM, true},
+	"2022-blake3-chacha20-poly1305": {32, chacha20poly1305.New, true},
+}
+
+type ssTestStream struct {
+	a     cipher.AEAD
+	nonce []byte
+}
+
+func newSSTestStream(m ssTestMethod, key, salt []byte) *ssTestStream {
+	sub := make([]byte, m.keySize)
+	if m.v2022 {
+		blake3.DeriveKey(sub, "shadowsocks 2022 session subkey", append(append([]byte{}, key...), salt...))
+	} else {
+		_, _ = io.ReadFull(hkdf.New(sha1.New, key, salt, []byte("ss-subkey")), sub)
+	}
+	a, _ := m.aead(sub)
+	return &ssTestStream{a: a, nonce: make([]byte, a.NonceSize())}
+}
+
+func (s *ssTestStream) bump() {
+	for i := range s.nonce {
+		if s.nonce[i]++; s.nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+func (s *ssTestStream) seal(dst, p []byte) []byte {
+	dst = s.a.Seal(dst, s.nonce, p, nil)
+	s.bump()
+	return dst
+}
+
+func (s *ssTestStream) read(r io.Reader, n int) ([]byte, error) {
+	b := make([]byte, n+s.a.Overhead())
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	out, err := s.a.Open(nil, s.nonce, b, nil)
+	s.bump()
+	return out, err
+}
+
+func ssTestKey(m ssTestMethod, password string) []byte {
+	if m.v2022 {
+		k, _ := base64.StdEncoding.DecodeString(password)
+		return k
+	}
+	var key, prev []byte
+	for len(key) < m.keySize {
+		h := md5.Sum(append(prev, password...))
+		prev = h[:]
+		key = append(key, prev...)
+	}
+	return key[:m.keySize]
+}
+
+// ssServer is a stand-in shadowsocks AEAD server: it decrypts the request
+// (address and first payload), relays it to the target and encrypts the
+// answer. A client with the wrong key fails authentication and is dropped.
+func ssServer(t *testing.T, method, password string) int {
+	m := ssTestMethods[method]
+	key := ssTestKey(m, password)
+	return listen(t, func(c net.Conn) {
+		salt := make([]byte, m.keySize)
+		if _, err := io.ReadFull(c, salt); err != nil {
+			return
+		}
+		r := newSSTestStream(m, key, salt)
+		var payload []byte
+		if m.v2022 {
+			fixed, err := r.read(c, 11)
+			if err != nil || fixed[0] != 0 {
+				return
+			}
+			head, err := r.read(c, int(binary.BigEndian.Uint16(fixed[9:])))
+			if err != nil {
+				return
+			}
+			payload = head
+		} else {
+			l, err := r.read(c, 2)
+			if err != nil {
+				return
+			}
+			if payload, err = r.read(c, int(binary.BigEndian.Uint16(l))); err != nil {
+				return
+			}
+		}
+		target, rest := readSocksAddr(payload)
+		if m.v2022 {
+			pad := int(binary.BigEndian.Uint16(rest))
+			rest = rest[2+pad:]
+		}
+		resp := fetchTarget(target, rest)
+
+		out := make([]byte, m.keySize)
+		_, _ = rand.Read(out)
+		w := newSSTestStream(m, key, out)
+		if m.v2022 {
+			fixed := binary.BigEndian.AppendUint64([]byte{1}, uint64(time.Now().Unix()))
+			fixed = append(fixed, salt...)
+			fixed = binary.BigEndian.AppendUint16(fixed, uint16(len(resp)))
+			out = w.seal(out, fixed)
+			out = w.seal(out, resp)
+		} else {
+			out = w.seal(out, binary.BigEndian.AppendUint16(nil, uint16(len(resp))))
+			out = w.seal(out, resp)
+		}
+		_, _ = c.Write(out)
+	})
+}
+
+// trojanServer is a stand-in trojan server. Like the real thing it hands
+// unauthenticated connections to its fallback web server.
+func trojanServer(t *testing.T, password string) int {
+	cert := httptest.NewTLSServer(http.NotFoundHandler())
+	cfg := cert.TLS.Clone()
+	cert.Close()
+	sum := sha256.Sum224([]byte(password))
+	want := hex.EncodeToString(sum[:])
+	return listen(t, func(raw net.Conn) {
+		c := tls.Server(raw, cfg)
+		br := bufio.NewReader(c)
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.TrimSpace(line) != want {
+			_, _ = c.Write([]byte("HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+			return
+		}
+		buf := make([]byte, 512)
+		n, _ := io.ReadAtLeast(br, buf, 8)
+		if buf[0] != 1 {
+			return
+		}
+		target, rest := readSocksAddr(buf[1:n])
+		rest = bytes.TrimPrefix(rest, []byte("\r\n"))
+		// the request may arrive after the header in a separate record
+		if !bytes.Contains(rest, []byte("\r\n\r\n")) {
+			more := make([]byte, 4096)
+			m, _ := br.Read(more)
+			rest = append(rest, more[:m]...)
+		}
+		_, _ = c.Write(fetchTarget(target, rest))
+	})
+}
+
+func TestHandshakeShadowsocks(t *testing.T) {
+	target := handshakeTarget(t)
+	psk32 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	for method, pw := range map[string]string{
+		"aes-128-gcm":                   "secret",
+		"chacha20-ietf-poly1305":        "secret",
+		"2022-blake3-aes-256-gcm":       psk32,
+		"2022-blake3-chacha20-poly1305": psk32,
+	} {
+		t.Run(method, func(t *testing.T) {
+			port := ssServer(t, method, pw)
+			node := probe.Node{Proto: "ss", Host: "127.0.0.1", Port: port, Cipher: method, Password: pw}
+			opt := probe.Options{Timeout: 2 * time.Second, HandshakeURL: target}
+			if r := (probe.LocalOrigin{}).ProbeNode(context.Background(), node, opt); !r.Success || r.Method != "ss" {
+				t.Fatalf("expected ss handshake success, got %+v", r)
+			}
+			node.Password = "wrong"
+			if strings.HasPrefix(method, "2022") {
+				node.Password = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{8}, 32))
+			}
+			if r := (probe.LocalOrigin{}).ProbeNode(context.Background(), node, opt); r.Success {
+				t.Fatalf("expected wrong key to fail, got %+v", r)
+			}
+		})
+	}
+}
+
+func TestHandshakeTrojan(t *testing.T) {
+	target := handshakeTarget(t)
+	port := trojanServer(t, "hunter2")
+	node := probe.Node{Proto: "trojan", Host: "127.0.0.1", Port: port, TLS: true, SNI: "example.com", Password: "hunter2"}
+	opt := probe.Options{Timeout: 2 * time.Second, HandshakeURL: target}
+	if r := (probe.LocalOrigin{}).ProbeNode(context.Background(), node, opt); !r.Success || r.Method != "trojan" {
+		t.Fatalf("expected trojan handshake success, got %+v", r)
+	}
+	// the fallback site answers, but not with the target's response
+	node.Password = "wrong"
+	if r := (probe.LocalOrigin{}).ProbeNode(context.Background(), node, opt); r.Success || r.Err != "trojan_status_400" {
+		t.Fatalf("expected fallback to be rejected, got %+v", r)
+	}
+}
+
+// TestHandshakeSkipped checks nodes we cannot speak to keep the
+// reachability stages.
+func TestHandshakeSkipped(t *testing.T) {
+	target := handshakeTarget(t)
+	port := listen(t, func(net.Conn) {})
+	node := probe.Node{Proto: "ss", Host: "127.0.0.1", Port: port, Cipher: "aes-128-gcm", Password: "x", Plugin: "obfs-local"}
+	r := (probe.LocalOrigin{}).ProbeNode(context.Background(), node, probe.Options{Timeout: time.Second, HandshakeURL: target})
+	if !r.Success || r.Method != "tcp" {
+		t.Fatalf("expected tcp stage for a plugin node, got %+v", r)
+	}
+}
+
+func TestHandshakeFetchCheck(t *testing.T) {
+	target := handshakeTarget(t)
+	echo := strings.TrimSuffix(target, "/generate_204") + "/echo"
+	port := ssServer(t, "aes-128-gcm", "secret")
+	node := probe.Node{Proto: "ss", Host: "127.0.0.1", Port: port, Cipher: "aes-128-gcm", Password: "secret"}
+	sum := sha256.Sum256([]byte("hello probe"))
+	closed := listen(t, func(net.Conn) {})
+
+	cases := []struct {
+		name  string
+		fetch probe.FetchCheck
+		err   string
+	}{
+		{"body", probe.FetchCheck{URL: echo, ExpectStatus: 200, ExpectBody: "hello"}, ""},
+		{"hash", probe.FetchCheck{URL: echo, ExpectSHA256: hex.EncodeToString(sum[:])}, ""},
+		{"status", probe.FetchCheck{URL: echo, ExpectStatus: 204}, "fetch_status_200"},
+		{"body_mismatch", probe.FetchCheck{URL: echo, ExpectBody: "goodbye"}, "fetch_body_mismatch"},
+		{"hash_mismatch", probe.FetchCheck{URL: echo, ExpectSHA256: strings.Repeat("0", 64)}, "fetch_hash_mismatch"},
+		{"too_large", probe.FetchCheck{URL: echo, ExpectSHA256: hex.EncodeToString(sum[:]), MaxBodyBytes: 4}, "fetch_body_too_large"},
+		// the handshake passes but the tunnel goes nowhere
+		{"black_hole", probe.FetchCheck{URL: fmt.Sprintf("http://127.0.0.1:%d/", closed)}, "fetch_no_response"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opt := probe.Options{Timeout: 2 * time.Second, HandshakeURL: target, Fetch: c.fetch}
+			r := (probe.LocalOrigin{}).ProbeNode(context.Background(), node, opt)
+			if c.err == "" {
+				if !r.Success || r.Method != "ss" || r.TTFB <= 0 {
+					t.Fatalf("expected fetch check to pass with a ttfb, got %+v", r)
+				}
+				return
+			}
+			if r.Success || !strings.HasPrefix(r.Err, c.err) {
+				t.Fatalf("expected %s, got %+v", c.err, r)
+			}
+		})
+	}
+}
+
+func TestThroughput(t *testing.T) {
+	blob := strings.TrimSuffix(handshakeTarget(t), "/generate_204") + "/blob"
+	port := trojanServer(t, "hunter2")
+	node := probe.Node{Proto: "trojan", Host: "127.0.0.1", Port: port, TLS: true, SNI: "example.com", Password: "hunter2"}
+	r := probe.Throughput(context.Background(), node, probe.ThroughputCheck{URL: blob, MaxBytes: 64 << 10}, 2*time.Second)
+	if r.Err != "" || r.Bytes != 64<<10 || r.BytesPerSec <= 0 {
+		t.Fatalf("expected a capped download, got %+v", r)
+	}
+	node.Password = "wrong"
+	if r := probe.Throughput(context.Background(), node, probe.ThroughputCheck{URL: blob}, 2*time.Second); r.Err != "throughput_status_400" {
+		t.Fatalf("expected the fallback to fail, got %+v", r)
+	}
+}
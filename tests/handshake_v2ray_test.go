@@ -0,0 +1,498 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/yasi-python/go/pkg/probe"
+)
+
+const testUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+func uuidBytes(s string) []byte {
+	b, _ := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	return b
+}
+
+// readPortFirstAddr parses the VLESS/VMess address: port, type, address.
+func readPortFirstAddr(r io.Reader) (string, error) {
+	var h [3]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return "", err
+	}
+	port := binary.BigEndian.Uint16(h[:2])
+	var host string
+	switch h[2] {
+	case 1, 3:
+		ip := make([]byte, map[byte]int{1: 4, 3: 16}[h[2]])
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case 2:
+		var l [1]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return "", err
+		}
+		d := make([]byte, l[0])
+		if _, err := io.ReadFull(r, d); err != nil {
+			return "", err
+		}
+		host = string(d)
+	default:
+		return "", errors.New("bad address type")
+	}
+	return net.JoinHostPort(host, fmt.Sprint(port)), nil
+}
+
+// relayHTTP reads one HTTP request from r and returns the target's answer.
+func relayHTTP(r io.Reader, target string) []byte {
+	req, err := http.ReadRequest(bufio.NewReader(r))
+	if err != nil {
+		return nil
+	}
+	var b bytes.Buffer
+	_ = req.Write(&b)
+	return fetchTarget(target, b.Bytes())
+}
+
+// serveVLESS is a stand-in VLESS server on an established stream.
+func serveVLESS(rw io.ReadWriter, uuid string) {
+	h := make([]byte, 18)
+	if _, err := io.ReadFull(rw, h); err != nil || h[0] != 0 || !bytes.Equal(h[1:17], uuidBytes(uuid)) {
+		return
+	}
+	addons := make([]byte, int(h[17])+1)
+	if _, err := io.ReadFull(rw, addons); err != nil || addons[len(addons)-1] != 1 {
+		return
+	}
+	target, err := readPortFirstAddr(rw)
+	if err != nil {
+		return
+	}
+	_, _ = rw.Write(append([]byte{0, 0}, relayHTTP(rw, target)...))
+}
+
+func testKDF(key []byte, path ...[]byte) []byte {
+	h := func() hash.Hash { return hmac.New(sha256.New, []byte("VMess AEAD KDF")) }
+	for _, p := range path {
+		parent, p := h, p
+		h = func() hash.Hash { return hmac.New(parent, p) }
+	}
+	m := h()
+	m.Write(key)
+	return m.Sum(nil)
+}
+
+// vmessChunks reads or writes the VMess body chunk stream.
+type vmessChunks struct {
+	rw    io.ReadWriter
+	aead  cipher.AEAD
+	iv    []byte
+	count uint16
+	buf   []byte
+}
+
+func newVMessChunks(rw io.ReadWriter, sec byte, key, iv []byte) *vmessChunks {
+	c := &vmessChunks{rw: rw, iv: iv}
+	switch sec {
+	case 3:
+		c.aead, _ = testGCM(key)
+	case 4:
+		k := md5.Sum(key)
+		k2 := md5.Sum(k[:])
+		c.aead, _ = chacha20poly1305.New(append(k[:], k2[:]...))
+	}
+	return c
+}
+
+func (c *vmessChunks) nonce() []byte {
+	n := binary.BigEndian.AppendUint16(nil, c.count)
+	c.count++
+	return append(n, c.iv[2:12]...)
+}
+
+func (c *vmessChunks) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		var l [2]byte
+		if _, err := io.ReadFull(c.rw, l[:]); err != nil {
+			return 0, err
+		}
+		b := make([]byte, binary.BigEndian.Uint16(l[:]))
+		if _, err := io.ReadFull(c.rw, b); err != nil {
+			return 0, err
+		}
+		if c.aead != nil {
+			var err error
+			if b, err = c.aead.Open(nil, c.nonce(), b, nil); err != nil {
+				return 0, err
+			}
+		}
+		c.buf = b
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *vmessChunks) seal(p []byte) []byte {
+	if c.aead != nil {
+		p = c.aead.Seal(nil, c.nonce(), p, nil)
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(p))), p...)
+}
+
+// serveVMess is a stand-in VMess AEAD server on an established stream.
+func serveVMess(rw io.ReadWriter, uuid string) {
+	cmdKey := md5.Sum(append(uuidBytes(uuid), "c48619fe-8f02-49e0-b9e9-edf763e17e21"...))
+	pre := make([]byte, 16+18+8)
+	if _, err := io.ReadFull(rw, pre); err != nil {
+		return
+	}
+	authID, encLen, nonce := pre[:16], pre[16:34], pre[34:]
+	block, _ := aes.NewCipher(testKDF(cmdKey[:], []byte("AES Auth ID Encryption"))[:16])
+	var aid [16]byte
+	block.Decrypt(aid[:], authID)
+	ts := time.Unix(int64(binary.BigEndian.Uint64(aid[:8])), 0)
+	if crc32.ChecksumIEEE(aid[:12]) != binary.BigEndian.Uint32(aid[12:]) || time.Since(ts).Abs() > 2*time.Minute {
+		return
+	}
+	open := func(key, iv string, p []byte) ([]byte, error) {
+		a, _ := testGCM(testKDF(cmdKey[:], []byte(key), authID, nonce)[:16])
+		return a.Open(nil, testKDF(cmdKey[:], []byte(iv), authID, nonce)[:12], p, authID)
+	}
+	l, err := open("VMess Header AEAD Key_Length", "VMess Header AEAD Nonce_Length", encLen)
+	if err != nil {
+		return
+	}
+	enc := make([]byte, int(binary.BigEndian.Uint16(l))+16)
+	if _, err := io.ReadFull(rw, enc); err != nil {
+		return
+	}
+	h, err := open("VMess Header AEAD Key", "VMess Header AEAD Nonce", enc)
+	if err != nil || h[0] != 1 {
+		return
+	}
+	f := fnv.New32a()
+	f.Write(h[:len(h)-4])
+	if !bytes.Equal(f.Sum(nil), h[len(h)-4:]) {
+		return
+	}
+	reqIV, reqKey, respV, option, sec := h[1:17], h[17:33], h[33], h[34], h[35]&0x0f
+	target, err := readPortFirstAddr(bytes.NewReader(h[38:]))
+	if err != nil {
+		return
+	}
+
+	var in io.Reader = rw
+	if option&1 != 0 {
+		in = newVMessChunks(rw, sec, reqKey, reqIV)
+	}
+	resp := relayHTTP(in, target)
+
+	rk := sha256.Sum256(reqKey)
+	ri := sha256.Sum256(reqIV)
+	seal := func(key, iv string, p []byte) []byte {
+		a, _ := testGCM(testKDF(rk[:16], []byte(key))[:16])
+		return a.Seal(nil, testKDF(ri[:16], []byte(iv))[:12], p, nil)
+	}
+	head := []byte{respV, option, 0, 0}
+	out := seal("AEAD Resp Header Len Key", "AEAD Resp Header Len IV", binary.BigEndian.AppendUint16(nil, uint16(len(head))))
+	out = append(out, seal("AEAD Resp Header Key", "AEAD Resp Header IV", head)...)
+	if option&1 != 0 {
+		w := newVMessChunks(rw, sec, rk[:16], ri[:16])
+		out = append(out, w.seal(resp)...)
+		out = append(out, w.seal(nil)...)
+	} else {
+		out = append(out, resp...)
+	}
+	_, _ = rw.Write(out)
+}
+
+func testTLSConfig() *tls.Config {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	return srv.TLS.Clone()
+}
+
+// wsStream is the server end of a websocket: masked frames in, plain out.
+type wsStream struct {
+	br  *bufio.Reader
+	w   io.Writer
+	buf []byte
+}
+
+func (s *wsStream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		var h [2]byte
+		if _, err := io.ReadFull(s.br, h[:]); err != nil {
+			return 0, err
+		}
+		size := uint64(h[1] & 0x7f)
+		switch size {
+		case 126:
+			var b [2]byte
+			_, _ = io.ReadFull(s.br, b[:])
+			size = uint64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			_, _ = io.ReadFull(s.br, b[:])
+			size = binary.BigEndian.Uint64(b[:])
+		}
+		var mask [4]byte
+		if _, err := io.ReadFull(s.br, mask[:]); err != nil {
+			return 0, err
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(s.br, b); err != nil {
+			return 0, err
+		}
+		for i := range b {
+			b[i] ^= mask[i%4]
+		}
+		s.buf = b
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *wsStream) Write(p []byte) (int, error) {
+	f := []byte{0x82}
+	if len(p) < 126 {
+		f = append(f, byte(len(p)))
+	} else {
+		f = binary.BigEndian.AppendUint16(append(f, 126), uint16(len(p)))
+	}
+	_, err := s.w.Write(append(f, p...))
+	return len(p), err
+}
+
+func wsServer(t *testing.T, path string, serve func(io.ReadWriter)) int {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path || r.Header.Get("Upgrade") != "websocket" {
+			http.NotFound(w, r)
+			return
+		}
+		c, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		_, _ = c.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"))
+		serve(&wsStream{br: brw.Reader, w: c})
+	}))
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().(*net.TCPAddr).Port
+}
+
+// gunStream is the server end of a gun stream.
+type gunStream struct {
+	r   io.Reader
+	w   http.ResponseWriter
+	buf []byte
+}
+
+func (s *gunStream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		var h [5]byte
+		if _, err := io.ReadFull(s.r, h[:]); err != nil {
+			return 0, err
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(h[1:]))
+		if _, err := io.ReadFull(s.r, msg); err != nil {
+			return 0, err
+		}
+		size, k := binary.Uvarint(msg[1:])
+		s.buf = msg[1+k : 1+k+int(size)]
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *gunStream) Write(p []byte) (int, error) {
+	hunk := append(binary.AppendUvarint([]byte{0x0a}, uint64(len(p))), p...)
+	_, err := s.w.Write(append(binary.BigEndian.AppendUint32([]byte{0}, uint32(len(hunk))), hunk...))
+	s.w.(http.Flusher).Flush()
+	return len(p), err
+}
+
+func grpcServer(t *testing.T, service string, serve func(io.ReadWriter)) int {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path != "/"+service+"/Tun" {
+			// grpc-go: trailers-only UNIMPLEMENTED
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Grpc-Status", "12")
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		serve(&gunStream{r: r.Body, w: w})
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().(*net.TCPAddr).Port
+}
+
+// h2Body is the server end of an h2 transport stream.
+type h2Body struct {
+	io.Reader
+	w http.ResponseWriter
+}
+
+func (s h2Body) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.w.(http.Flusher).Flush()
+	return n, err
+}
+
+func h2Server(t *testing.T, path string, serve func(io.ReadWriter)) int {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Method != "PUT" || r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		serve(h2Body{Reader: r.Body, w: w})
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().(*net.TCPAddr).Port
+}
+
+// v2rayServer starts serve behind the given transport and returns the
+// node pointing at it.
+func v2rayServer(t *testing.T, proto, transport string, serve func(io.ReadWriter)) probe.Node {
+	n := probe.Node{Proto: proto, Host: "127.0.0.1", Transport: transport}
+	switch transport {
+	case "tcp":
+		n.Port = listen(t, func(c net.Conn) { serve(c) })
+	case "tls":
+		cfg := testTLSConfig()
+		n.Transport, n.TLS, n.SNI = "tcp", true, "example.com"
+		n.Port = listen(t, func(c net.Conn) { serve(tls.Server(c, cfg)) })
+	case "ws":
+		n.Path = "/ray?ed=2048"
+		n.Port = wsServer(t, "/ray", serve)
+	case "grpc":
+		n.TLS, n.SNI, n.ServiceName = true, "example.com", "svc"
+		n.Port = grpcServer(t, "svc", serve)
+	case "h2":
+		n.TLS, n.SNI, n.Path, n.HostHeader = true, "example.com", "/h2", "example.com,example.org"
+		n.Port = h2Server(t, "/h2", serve)
+	}
+	return n
+}
+
+func TestHandshakeVLESS(t *testing.T) {
+	target := handshakeTarget(t)
+	opt := probe.Options{Timeout: 2 * time.Second, HandshakeURL: target}
+	for _, tr := range []string{"tcp", "tls", "ws", "grpc", "h2"} {
+		t.Run(tr, func(t *testing.T) {
+			node := v2rayServer(t, "vless", tr, func(rw io.ReadWriter) { serveVLESS(rw, testUUID) })
+			node.UUID, node.Cipher = testUUID, "none"
+			if r := (probe.LocalOrigin{}).ProbeNode(context.Background(), node, opt); !r.Success || r.Method != "vless" {
+				t.Fatalf("expected vless handshake success, got %+v", r)
+			}
+			node.UUID = "00000000-0000-0000-0000-000000000000"
+			if r := (probe.LocalOrigin{}).ProbeNode(context.Background(), node, opt); r.Success {
+				t.Fatalf("expected wrong uuid to fail, got %+v", r)
+			}
+		})
+	}
+}
+
+func TestHandshakeVMess(t *testing.T) {
+	target := handshakeTarget(t)
+	opt := probe.Options{Timeout: 2 * time.Second, HandshakeURL: target}
+	cases := []struct{ transport, cipher string }{
+		{"tcp", "auto"}, {"tcp", "chacha20-poly1305"}, {"tcp", "none"}, {"tcp", "zero"},
+		{"ws", "aes-128-gcm"}, {"grpc", "auto"},
+	}
+	for _, c := range cases {
+		t.Run(c.transport+"/"+c.cipher, func(t *testing.T) {
+			node := v2rayServer(t, "vmess", c.transport, func(rw io.ReadWriter) { serveVMess(rw, testUUID) })
+			node.UUID, node.Cipher = testUUID, c.cipher
+			if r := (probe.LocalOrigin{}).ProbeNode(context.Background(), node, opt); !r.Success || r.Method != "vmess" {
+				t.Fatalf("expected vmess handshake success, got %+v", r)
+			}
+			node.UUID = "00000000-0000-0000-0000-000000000000"
+			if r := (probe.LocalOrigin{}).ProbeNode(context.Background(), node, opt); r.Success {
+				t.Fatalf("expected wrong uuid to fail, got %+v", r)
+			}
+		})
+	}
+}
+
+// TestHandshakeVLESSFlowSkipped: vision flows are not spoken, so such
+// nodes keep the reachability stages.
+func TestHandshakeVLESSFlowSkipped(t *testing.T) {
+	target := handshakeTarget(t)
+	node := v2rayServer(t, "vless", "tcp", func(io.ReadWriter) {})
+	node.UUID, node.Flow = testUUID, "xtls-rprx-vision"
+	r := (probe.LocalOrigin{}).ProbeNode(context.Background(), node, probe.Options{Timeout: time.Second, HandshakeURL: target})
+	if !r.Success || r.Method != "tcp" {
+		t.Fatalf("expected tcp stage for a vision node, got %+v", r)
+	}
+}
+
+// TestTransportProbes checks grpc and h2 nodes without a handshake stage
+// are probed with their transport's request.
+func TestTransportProbes(t *testing.T) {
+	drain := func(rw io.ReadWriter) { _, _ = io.Copy(io.Discard, rw) }
+	opt := probe.Options{Timeout: 2 * time.Second}
+	cases := []struct {
+		transport, method string
+		mutate            func(*probe.Node)
+		err               string
+	}{
+		{"grpc", "grpc", func(*probe.Node) {}, ""},
+		{"grpc", "", func(n *probe.Node) { n.ServiceName = "other" }, "grpc_unimplemented"},
+		{"h2", "h2", func(*probe.Node) {}, ""},
+		{"h2", "", func(n *probe.Node) { n.Path = "/wrong" }, "h2_status_404"},
+	}
+	for _, c := range cases {
+		node := v2rayServer(t, "vless", c.transport, drain)
+		c.mutate(&node)
+		r := (probe.LocalOrigin{}).ProbeNode(context.Background(), node, opt)
+		if r.Success != (c.err == "") || r.Method != c.method || r.Err != c.err {
+			t.Errorf("%s %s: got %+v", c.transport, c.err, r)
+		}
+	}
+}
@@ -2,6 +2,11 @@ package tests
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -12,7 +17,189 @@ func TestLocalProbeUntestable(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
 	defer cancel()
 	res := probe.LocalOrigin{}.ProbeNode(ctx, probe.Node{Host:"",Port:0}, probe.Options{Timeout: 300*time.Millisecond})
-	if !res.Success {
-		t.Fatalf("untestable should be success=true for safety")
+	if res.Success || res.Method != "untested" || res.Err != probe.ErrUntested.Error() {
+		t.Fatalf("untestable should report ErrUntested, got %+v", res)
 	}
-}
\ No newline at end of file
+}
+
+// TestLocalProbeUncheckable covers nodes with a host that no stage can
+// reach: they report ErrUntested instead of a success, so they never
+// build up stats and are not exported as healthy.
+func TestLocalProbeUncheckable(t *testing.T) {
+	for _, n := range []probe.Node{
+		{Proto: "wireguard", Host: "127.0.0.1", Port: 51820},
+		{Proto: "hysteria2", Host: "127.0.0.1", Port: 443, Obfs: "salamander"},
+	} {
+		res := probe.LocalOrigin{}.ProbeNode(context.Background(), n, probe.Options{Timeout: 300 * time.Millisecond})
+		if res.Success || res.Method != "untested" || res.Err != probe.ErrUntested.Error() {
+			t.Errorf("%s: expected an untested non-success, got %+v", n.Proto, res)
+		}
+	}
+}
+
+// quicStandIn runs a UDP server that answers unknown-version long-header
+// packets with a Version Negotiation packet, ignoring the first drop ones.
+func quicStandIn(t *testing.T, drop int) net.PacketConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 1200 || buf[0]&0x80 == 0 {
+				continue
+			}
+			if drop > 0 {
+				drop--
+				continue
+			}
+			dl := int(buf[5])
+			dcid := buf[6 : 6+dl]
+			sl := int(buf[6+dl])
+			scid := buf[7+dl : 7+dl+sl]
+			vn := []byte{0x80, 0, 0, 0, 0, byte(sl)}
+			vn = append(vn, scid...)
+			vn = append(vn, byte(dl))
+			vn = append(vn, dcid...)
+			vn = append(vn, 0, 0, 0, 1)
+			_, _ = pc.WriteTo(vn, addr)
+		}
+	}()
+	return pc
+}
+
+// TestLocalProbeQUICVersionNegotiation probes the VN stand-in.
+func TestLocalProbeQUICVersionNegotiation(t *testing.T) {
+	pc := quicStandIn(t, 0)
+	defer pc.Close()
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res := probe.LocalOrigin{}.ProbeNode(ctx, probe.Node{Proto: "hysteria2", Host: "127.0.0.1", Port: port}, probe.Options{Timeout: 500 * time.Millisecond})
+	if !res.Success || res.Method != "quic" {
+		t.Fatalf("expected quic success, got %+v", res)
+	}
+
+	// nothing listening: the read times out
+	_ = pc.Close()
+	res = probe.LocalOrigin{}.ProbeNode(ctx, probe.Node{Proto: "tuic", Host: "127.0.0.1", Port: port}, probe.Options{Timeout: 200 * time.Millisecond})
+	if res.Success {
+		t.Fatalf("expected quic failure against closed port, got %+v", res)
+	}
+}
+
+// TestLocalProbeRetries checks that lost packets (timeouts) are retried
+// while a refused port is not.
+func TestLocalProbeRetries(t *testing.T) {
+	pc := quicStandIn(t, 2)
+	defer pc.Close()
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	opt := probe.Options{Timeout: 100 * time.Millisecond, Retries: 3, BackoffInitial: 10 * time.Millisecond, BackoffMax: 20 * time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	res := probe.LocalOrigin{}.ProbeNode(ctx, probe.Node{Proto: "hysteria2", Host: "127.0.0.1", Port: port}, opt)
+	if !res.Success || res.Attempts != 3 {
+		t.Fatalf("expected success on the third attempt, got %+v", res)
+	}
+
+	opt.Retries = 1
+	pc2 := quicStandIn(t, 5)
+	defer pc2.Close()
+	port2 := pc2.LocalAddr().(*net.UDPAddr).Port
+	res = probe.LocalOrigin{}.ProbeNode(ctx, probe.Node{Proto: "hysteria2", Host: "127.0.0.1", Port: port2}, opt)
+	if res.Success || !res.Retriable || res.Attempts != 2 {
+		t.Fatalf("expected two timed-out attempts, got %+v", res)
+	}
+
+	// a closed TCP port is refused: definitive, no retry
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+	res = probe.LocalOrigin{}.ProbeNode(ctx, probe.Node{Proto: "trojan", Host: "127.0.0.1", Port: closed}, opt)
+	if res.Success || res.Retriable || res.Attempts != 1 {
+		t.Fatalf("expected one definitive failure, got %+v", res)
+	}
+}
+
+// wsHandler answers RFC 6455 upgrades; a wrong accept key imitates a
+// middlebox that fakes the 101.
+func wsHandler(badAccept bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+			http.Error(w, "bad request", 400)
+			return
+		}
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		accept := base64.StdEncoding.EncodeToString(sum[:])
+		if badAccept {
+			accept = "AAAA" + accept[4:]
+		}
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Sec-WebSocket-Accept", accept)
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}
+}
+
+// TestLocalProbeHTTPStage covers path iteration and the ws upgrade signal.
+func TestLocalProbeHTTPStage(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ray", wsHandler(false))
+	mux.HandleFunc("/fake", wsHandler(true))
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("ok")) })
+	mux.HandleFunc("/", http.NotFound)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+	node := probe.Node{Proto: "vless", Host: "127.0.0.1", Port: addr.Port, Transport: "ws", Path: "/ray"}
+	opt := probe.Options{Timeout: time.Second, PreferHTTP: true, HTTPProbePaths: []string{"/", "/health"}}
+	ctx := context.Background()
+
+	if r := (probe.LocalOrigin{}).ProbeNode(ctx, node, opt); !r.Success || r.Method != "ws" || r.Path != "/ray" {
+		t.Fatalf("expected ws upgrade on /ray, got %+v", r)
+	}
+	// a 101 with the wrong accept key fails the node, even though /health
+	// answers
+	node.Path = "/fake"
+	if r := (probe.LocalOrigin{}).ProbeNode(ctx, node, opt); r.Success || r.Err != "ws_bad_accept" {
+		t.Fatalf("expected the faked upgrade to be rejected, got %+v", r)
+	}
+	node.Transport, node.Path = "tcp", "/missing"
+	if r := (probe.LocalOrigin{}).ProbeNode(ctx, node, opt); !r.Success || r.Method != "http" || r.Path != "/health" {
+		t.Fatalf("expected http success on /health, got %+v", r)
+	}
+	opt.PreferHTTP = false
+	if r := (probe.LocalOrigin{}).ProbeNode(ctx, node, opt); !r.Success || r.Method != "tcp" || r.Path != "" {
+		t.Fatalf("expected plain tcp stage, got %+v", r)
+	}
+}
+
+// TestLocalProbeWebSocketTLS checks SNI and Host reach the server during
+// the upgrade over TLS.
+func TestLocalProbeWebSocketTLS(t *testing.T) {
+	var sni, host string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sni, host = r.TLS.ServerName, r.Host
+		wsHandler(false)(w, r)
+	}))
+	defer srv.Close()
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+	node := probe.Node{Proto: "trojan", Host: "127.0.0.1", Port: addr.Port, TLS: true, SNI: "sni.example.com",
+		HostHeader: "cdn.example.com", Transport: "ws", Path: "/ws"}
+	r := (probe.LocalOrigin{}).ProbeNode(context.Background(), node, probe.Options{Timeout: time.Second, PreferHTTP: true})
+	if !r.Success || r.Method != "ws" {
+		t.Fatalf("expected ws over tls, got %+v", r)
+	}
+	if sni != "sni.example.com" || host != "cdn.example.com" {
+		t.Fatalf("server saw sni=%q host=%q", sni, host)
+	}
+}
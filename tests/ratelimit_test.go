@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yasi-python/go/pkg/probe"
+)
+
+func TestLimiterPerTarget(t *testing.T) {
+	waits := map[string]int{}
+	l := probe.NewLimiter(600, 1, []string{probe.ScopeNet24}) // one token per 100ms
+	l.OnWait = func(scope string, _ time.Duration) { waits[scope]++ }
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	for _, host := range []string{"10.0.0.1", "10.0.0.2", "10.0.1.1"} {
+		if err := l.Wait(ctx, host, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 10.0.0.2 shares 10.0.0.1's /24 and must have queued; 10.0.1.1 must not
+	if d := time.Since(start); d < 80*time.Millisecond || d > time.Second {
+		t.Fatalf("expected one ~100ms wait, took %v", d)
+	}
+	if waits["net24"] != 1 {
+		t.Fatalf("expected one throttled wait, got %v", waits)
+	}
+
+	// a token that cannot be earned before the deadline is refused at once
+	short, cancel2 := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel2()
+	if err := l.Wait(short, "10.0.1.1", 0); !errors.Is(err, probe.ErrThrottled) {
+		t.Fatalf("expected ErrThrottled, got %v", err)
+	}
+	// ...and was refunded: after one interval the target is free again
+	time.Sleep(110 * time.Millisecond)
+	start = time.Now()
+	if err := l.Wait(ctx, "10.0.1.1", 0); err != nil || time.Since(start) > 50*time.Millisecond {
+		t.Fatalf("refund missing: err=%v waited %v", err, time.Since(start))
+	}
+
+	if probe.NewLimiter(0, 1, nil).Wait(ctx, "10.0.0.1", 0) != nil {
+		t.Fatal("a disabled limiter must never throttle")
+	}
+}
+
+// TestLimiterOneTokenPerProbe checks that retries of one probe do not queue
+// for tokens of their own.
+func TestLimiterOneTokenPerProbe(t *testing.T) {
+	port := listen(t, func(c net.Conn) { _ = c.Close() }) // TLS sees EOF: retriable
+	l := probe.NewLimiter(6, 1, []string{probe.ScopeHost}) // one token per 10s
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	r := (probe.LocalOrigin{}).ProbeNode(ctx, probe.Node{Proto: "trojan", Host: "127.0.0.1", Port: port, TLS: true, Transport: "h2"},
+		probe.Options{Timeout: 300 * time.Millisecond, Retries: 2, BackoffInitial: 10 * time.Millisecond,
+			BackoffMax: 20 * time.Millisecond, Limiter: l})
+	if r.Attempts != 3 || r.Err == probe.ErrThrottled.Error() {
+		t.Fatalf("expected three attempts on one token, got %+v", r)
+	}
+}
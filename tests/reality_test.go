@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/yasi-python/go/pkg/probe"
+)
+
+// realityAuth opens the session id of a ClientHello the way a REALITY
+// server does and returns the auth key if it carries shortID.
+func realityAuth(priv *ecdh.PrivateKey, shortID, hello []byte) ([]byte, bool) {
+	if len(hello) < 71 || hello[38] != 32 {
+		return nil, false
+	}
+	// skip to the extensions: suites, compression methods
+	rest := hello[71:]
+	rest = rest[2+int(binary.BigEndian.Uint16(rest)):]
+	rest = rest[1+int(rest[0]):]
+	exts := rest[2:]
+	var share []byte
+	for len(exts) >= 4 {
+		typ, l := binary.BigEndian.Uint16(exts), int(binary.BigEndian.Uint16(exts[2:]))
+		if typ == 51 {
+			share = exts[4+2+4 : 4+l] // one x25519 share
+		}
+		exts = exts[4+l:]
+	}
+	pub, err := ecdh.X25519().NewPublicKey(share)
+	if err != nil {
+		return nil, false
+	}
+	authKey, _ := priv.ECDH(pub)
+	random := hello[6:38]
+	_, _ = io.ReadFull(hkdf.New(sha256.New, authKey, random[:20], []byte("REALITY")), authKey)
+	aad := append([]byte{}, hello...)
+	copy(aad[39:71], make([]byte, 32))
+	aead, _ := testGCM(authKey)
+	plain, err := aead.Open(nil, random[20:], hello[39:71], aad)
+	if err != nil || !bytes.Equal(plain[8:8+len(shortID)], shortID) {
+		return nil, false
+	}
+	return authKey, true
+}
+
+// realityServer stands in for a REALITY inbound: clients that authenticate
+// get a TLS 1.3 handshake with an ed25519 certificate signed by
+// HMAC-SHA512 under the auth key, all others are relayed to dest.
+func realityServer(t *testing.T, priv *ecdh.PrivateKey, shortID []byte, dest string) int {
+	return listen(t, func(c net.Conn) {
+		h := make([]byte, 5)
+		if _, err := io.ReadFull(c, h); err != nil {
+			return
+		}
+		hello := make([]byte, binary.BigEndian.Uint16(h[3:]))
+		if _, err := io.ReadFull(c, hello); err != nil {
+			return
+		}
+		rec := append(h, hello...)
+		authKey, ok := realityAuth(priv, shortID, hello)
+		if !ok {
+			up, err := net.Dial("tcp", dest)
+			if err != nil {
+				return
+			}
+			defer up.Close()
+			_, _ = up.Write(rec)
+			go func() { _, _ = io.Copy(up, c) }()
+			_, _ = io.Copy(c, up)
+			return
+		}
+		pub, key, _ := ed25519.GenerateKey(rand.Reader)
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+		der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, pub, key)
+		mac := hmac.New(sha512.New, authKey)
+		mac.Write(pub)
+		copy(der[len(der)-64:], mac.Sum(nil))
+		cfg := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+		_ = tls.Server(&bufConn{Conn: c, r: io.MultiReader(bytes.NewReader(rec), c)}, cfg).Handshake()
+	})
+}
+
+// bufConn replays already-read bytes before the rest of the stream.
+type bufConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func TestRealityProbe(t *testing.T) {
+	camouflage := httptest.NewUnstartedServer(http.NotFoundHandler())
+	camouflage.Config.ErrorLog = log.New(io.Discard, "", 0) // probes hang up mid-handshake
+	camouflage.StartTLS()
+	t.Cleanup(camouflage.Close)
+	priv, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	sid, _ := hex.DecodeString("6ba85179e30d4fc2")
+	port := realityServer(t, priv, sid, camouflage.Listener.Addr().String())
+	other, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	pbk := base64.RawURLEncoding.EncodeToString(priv.PublicKey().Bytes())
+
+	cases := []struct {
+		name, pbk, sid, err string
+	}{
+		{"ok", pbk, "6ba85179e30d4fc2", ""},
+		{"wrong short id", pbk, "6ba85179e30d4fc3", "reality_fallback"},
+		{"wrong public key", base64.RawURLEncoding.EncodeToString(other.PublicKey().Bytes()), "6ba85179e30d4fc2", "reality_fallback"},
+		{"bad public key", "not-a-key", "6ba85179e30d4fc2", "invalid_public_key"},
+	}
+	for _, c := range cases {
+		node := probe.Node{Proto: "vless", Host: "127.0.0.1", Port: port, TLS: true, SNI: "www.example.com",
+			Security: "reality", UUID: "u", PublicKey: c.pbk, ShortID: c.sid}
+		r := (probe.LocalOrigin{}).ProbeNode(context.Background(), node, probe.Options{Timeout: 2 * time.Second})
+		if c.err == "" && (!r.Success || r.Method != "reality") || c.err != "" && (r.Success || r.Err != c.err) {
+			t.Errorf("%s: got %+v", c.name, r)
+		}
+	}
+}
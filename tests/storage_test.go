@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/yasi-python/go/pkg/storage"
+	"github.com/yasi-python/go/pkg/uri"
+)
+
+// TestStorageMigratesV1 writes a record in the pre-versioning layout and
+// checks that Open upgrades it in place.
+func TestStorageMigratesV1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.bolt")
+	raw := "vless://id@example.com:443?security=tls&sni=sni.example.com&type=ws&path=%2Fws&host=cdn.example.com#old"
+	variant := "vless://id@example.com:443?host=cdn.example.com&path=%2Fws&type=ws&sni=sni.example.com&security=tls#renamed"
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("configs"))
+		if err != nil {
+			return err
+		}
+		v1 := `{"id":"a","raw":"` + raw + `","proto":"vless","host":"example.com","port":443,"quarantine":true,"deleted":false}`
+		if err := b.Put([]byte("a"), []byte(v1)); err != nil {
+			return err
+		}
+		// same server, different remark and parameter order
+		v1 = `{"id":"b","raw":"` + variant + `","proto":"vless","host":"example.com","port":443}`
+		if err := b.Put([]byte("b"), []byte(v1)); err != nil {
+			return err
+		}
+		sb, err := tx.CreateBucketIfNotExists([]byte("stats"))
+		if err != nil {
+			return err
+		}
+		if err := sb.Put([]byte("a"), []byte(`{"id":"a","attempts":3,"successes":2,"failures":1}`)); err != nil {
+			return err
+		}
+		return sb.Put([]byte("b"), []byte(`{"id":"b","attempts":2,"successes":2}`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+
+	sdb, err := storage.Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	n, _ := uri.Parse(raw)
+	c, err := sdb.GetConfig(n.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sdb.GetConfig("a"); err == nil {
+		t.Fatalf("old raw-hash key should be gone")
+	}
+	if len(c.Aliases) != 2 {
+		t.Fatalf("expected both variants as aliases, got %+v", c.Aliases)
+	}
+	st, err := sdb.GetStats(n.ID())
+	if err != nil || st.Attempts != 5 || st.Successes != 4 {
+		t.Fatalf("stats not merged: %+v %v", st, err)
+	}
+	if c.SchemaVersion != storage.SchemaVersion || !c.Quarantine {
+		t.Fatalf("bad migrated record: %+v", c)
+	}
+	if c.Transport != "ws" || c.Security != "tls" || c.SNI != "sni.example.com" || c.Path != "/ws" ||
+		c.HostHeader != "cdn.example.com" || c.Remark != "old" || c.FirstSeenUnix == 0 {
+		t.Fatalf("parsed fields not migrated: %+v", c)
+	}
+	_ = sdb.Close()
+
+	// reopening is a no-op
+	sdb, err = storage.Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	_ = sdb.Close()
+}
+
+// TestStorageBadSchemaVersion checks that Open refuses a stored version it
+// cannot read instead of re-running the migrations.
+func TestStorageBadSchemaVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.bolt")
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("state"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("schema_version"), []byte("x"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+	if sdb, err := storage.Open(path); err == nil {
+		_ = sdb.Close()
+		t.Fatal("expected bad_schema_version")
+	}
+}
+
+func TestStorageExportState(t *testing.T) {
+	sdb, err := storage.Open(filepath.Join(t.TempDir(), "db.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdb.Close()
+	if _, err := sdb.GetExportState("out/a.txt"); err == nil {
+		t.Fatal("expected not_found")
+	}
+	want := storage.ExportState{Path: "out/a.txt", Hash: "ab", Bytes: 2, WrittenUnix: 42}
+	if err := sdb.PutExportState(want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := sdb.GetExportState("out/a.txt")
+	if err != nil || *got != want {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+}
+
+func TestStorageThroughput(t *testing.T) {
+	sdb, err := storage.Open(filepath.Join(t.TempDir(), "db.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdb.Close()
+	if _, err := sdb.UpdateStatsForProbe("a", storage.ProbeOutcome{Success: true, Latency: 80 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sdb.UpdateThroughput("a", 1.5e6); err != nil {
+		t.Fatal(err)
+	}
+	s, err := sdb.GetStats("a")
+	if err != nil || s.ThroughputBPS != 1.5e6 || s.ThroughputUnix == 0 || s.Successes != 1 {
+		t.Fatalf("got %+v, %v", s, err)
+	}
+}
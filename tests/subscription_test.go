@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/yasi-python/go/internal/subscription"
+	"github.com/yasi-python/go/pkg/uri"
+)
+
+func TestExtractNodesSchemes(t *testing.T) {
+	vm := "vmess://" + base64.StdEncoding.EncodeToString([]byte(`{"add":"1.2.3.4","port":"443","id":"x"}`))
+	list := strings.Join([]string{
+		vm,
+		"hy2://pw@hy.example.com:443#a",
+		"hysteria2://pw@hy.example.com:443#b",
+		"tuic://u:p@tuic.example.com:443#c",
+		"wireguard://k@1.1.1.1:2408?publickey=p#d",
+		"ssr://MS4yLjMuNDo4Mzg4Om9yaWdpbjphZXMtMjU2LWNmYjpwbGFpbjpjR0Z6Y3cv",
+		"trojan://pw@t.example.com:443#e",
+	}, "\n")
+	nodes := subscription.ExtractNodes(base64.StdEncoding.EncodeToString([]byte(list)))
+	if len(nodes) != 7 {
+		t.Fatalf("expected 7 nodes, got %d: %v", len(nodes), nodes)
+	}
+	for _, n := range nodes {
+		// a bare ss:// must never be carved out of a vmess:// link
+		if strings.HasPrefix(n, "ss://") {
+			t.Fatalf("bogus ss node extracted from vmess: %s", n)
+		}
+	}
+}
+
+const clashDoc = `
+port: 7890
+proxies:
+  - name: "ss-obfs"
+    type: ss
+    server: ss.example.com
+    port: 8388
+    cipher: aes-256-gcm
+    password: "pw"
+    plugin: obfs
+    plugin-opts: {mode: tls, host: bing.com}
+  - name: vmess-ws
+    type: vmess
+    server: 1.2.3.4
+    port: "443"
+    uuid: 33aa57df-1c93-4318-9fce-e850437ee781
+    alterId: 0
+    cipher: auto
+    tls: true
+    servername: sni.example.com
+    network: ws
+    ws-opts: {path: /ws, headers: {Host: cdn.example.com}}
+  - name: vless-reality
+    type: vless
+    server: r.example.com
+    port: 443
+    uuid: 0b1e7c9a-1f58-4234-b5fe-a895bee9a047
+    network: grpc
+    grpc-opts: {grpc-service-name: svc}
+    flow: xtls-rprx-vision
+    servername: www.microsoft.com
+    client-fingerprint: chrome
+    reality-opts: {public-key: pbk, short-id: "6ba8"}
+  - {name: trojan, type: trojan, server: t.example.com, port: 443, password: pw, sni: t.example.com}
+  - {name: hy2, type: hysteria2, server: hy.example.com, ports: "443,5000-6000", password: pw, obfs: salamander, obfs-password: op}
+  - {name: tuic, type: tuic, server: tuic.example.com, port: 443, uuid: 2dd61d93-75d8-4da4-ac0e-6aecb1e1ab7c, password: pw, congestion-controller: bbr}
+  - {name: weak, type: ss, server: 1.1.1.1, port: 1, cipher: rc4-md5, password: x}
+  - {name: direct, type: http, server: 1.1.1.1, port: 80}
+proxy-groups: []
+`
+
+func TestDecodeClash(t *testing.T) {
+	nodes, format, skipped, err := subscription.Decode(clashDoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != subscription.FormatClash || len(nodes) != 6 || skipped != 2 {
+		t.Fatalf("format=%s nodes=%d skipped=%d: %v", format, len(nodes), skipped, nodes)
+	}
+	byRemark := map[string]*uri.Node{}
+	for _, raw := range nodes {
+		n, err := uri.Parse(raw)
+		if err != nil {
+			t.Fatalf("decoded link does not parse: %s: %v", raw, err)
+		}
+		byRemark[n.Remark] = n
+	}
+	if n := byRemark["ss-obfs"]; n.Plugin != "obfs-local" || n.PluginOpt("obfs") != "tls" || n.PluginOpt("obfs-host") != "bing.com" {
+		t.Fatalf("bad ss: %+v", n)
+	}
+	if n := byRemark["vmess-ws"]; n.Transport != "ws" || n.Path != "/ws" || n.HostHeader != "cdn.example.com" || !n.TLS() || n.SNI != "sni.example.com" {
+		t.Fatalf("bad vmess: %+v", n)
+	}
+	if n := byRemark["vless-reality"]; n.Security != "reality" || n.PublicKey != "pbk" || n.ServiceName != "svc" || n.Flow != "xtls-rprx-vision" {
+		t.Fatalf("bad vless: %+v", n)
+	}
+	if n := byRemark["trojan"]; !n.TLS() || n.Password != "pw" {
+		t.Fatalf("bad trojan: %+v", n)
+	}
+	if n := byRemark["hy2"]; n.Port != 443 || n.Ports != "443,5000-6000" || n.Obfs != "salamander" {
+		t.Fatalf("bad hy2: %+v", n)
+	}
+	if n := byRemark["tuic"]; n.CongestionControl != "bbr" || n.UUID == "" {
+		t.Fatalf("bad tuic: %+v", n)
+	}
+}
+
+func TestDecodeSingBoxAndXray(t *testing.T) {
+	singBox := `{"log":{},"outbounds":[
+	  {"type":"selector","tag":"proxy","outbounds":["a"]},
+	  {"type":"vless","tag":"sb-reality","server":"r.example.com","server_port":443,"uuid":"u1","flow":"xtls-rprx-vision",
+	   "tls":{"enabled":true,"server_name":"www.apple.com","utls":{"enabled":true,"fingerprint":"chrome"},
+	          "reality":{"enabled":true,"public_key":"pbk","short_id":"ab"}}},
+	  {"type":"vmess","tag":"sb-ws","server":"1.2.3.4","server_port":80,"uuid":"u2","security":"auto",
+	   "transport":{"type":"ws","path":"/ws","headers":{"Host":"cdn.example.com"}}},
+	  {"type":"shadowsocks","tag":"sb-ss","server":"1.2.3.4","server_port":8388,"method":"aes-128-gcm","password":"pw"},
+	  {"type":"hysteria2","tag":"sb-hy2","server":"hy.example.com","server_port":443,"password":"pw",
+	   "obfs":{"type":"salamander","password":"op"},"tls":{"enabled":true,"server_name":"hy.example.com"}},
+	  {"type":"naive","tag":"unsupported","server":"n.example.com","server_port":443},
+	  {"type":"direct","tag":"direct"}
+	]}`
+	nodes, format, skipped, err := subscription.Decode(singBox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != subscription.FormatSingBox || len(nodes) != 4 || skipped != 1 {
+		t.Fatalf("format=%s nodes=%d skipped=%d", format, len(nodes), skipped)
+	}
+	n, _ := uri.Parse(nodes[0])
+	if n.Proto != "vless" || n.Security != "reality" || n.PublicKey != "pbk" || n.SNI != "www.apple.com" || n.Fingerprint != "chrome" {
+		t.Fatalf("bad sing-box vless: %+v", n)
+	}
+	n, _ = uri.Parse(nodes[1])
+	if n.Transport != "ws" || n.HostHeader != "cdn.example.com" || n.Path != "/ws" {
+		t.Fatalf("bad sing-box vmess: %+v", n)
+	}
+
+	xray := `[{"outbounds":[
+	  {"protocol":"vless","tag":"x-grpc","settings":{"vnext":[{"address":"g.example.com","port":443,"users":[{"id":"u3","encryption":"none"}]}]},
+	   "streamSettings":{"network":"grpc","security":"tls","grpcSettings":{"serviceName":"svc","multiMode":true},
+	                     "tlsSettings":{"serverName":"g.example.com","alpn":["h2"]}}},
+	  {"protocol":"freedom","tag":"direct"}]},
+	 {"outbounds":[
+	  {"protocol":"trojan","tag":"x-trojan","settings":{"servers":[{"address":"t.example.com","port":443,"password":"pw"}]},
+	   "streamSettings":{"network":"ws","wsSettings":{"path":"/t","headers":{"Host":"h.example.com"}}}}]}]`
+	nodes, format, skipped, err = subscription.Decode(xray)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != subscription.FormatXray || len(nodes) != 2 || skipped != 0 {
+		t.Fatalf("format=%s nodes=%d skipped=%d", format, len(nodes), skipped)
+	}
+	n, _ = uri.Parse(nodes[0])
+	if n.Transport != "grpc" || n.ServiceName != "svc" || n.Mode != "multi" || !n.TLS() || n.ALPN[0] != "h2" {
+		t.Fatalf("bad xray vless: %+v", n)
+	}
+	n, _ = uri.Parse(nodes[1])
+	if n.Proto != "trojan" || !n.TLS() || n.Path != "/t" || n.HostHeader != "h.example.com" {
+		t.Fatalf("bad xray trojan: %+v", n)
+	}
+}
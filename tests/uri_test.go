@@ -0,0 +1,247 @@
+package tests
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/yasi-python/go/pkg/uri"
+)
+
+func TestParseVMess(t *testing.T) {
+	body := `{"v":"2","ps":"RELAY-1","add":"198.41.203.3","port":"443","id":"33aa57df-1c93-4318-9fce-e850437ee781",` +
+		`"aid":0,"net":"ws","type":"none","host":"cdn.example.com","path":"dongtaiwang.com","tls":"tls",` +
+		`"sni":"sni.example.com","alpn":"h2,http/1.1","fp":"chrome"}`
+	n, err := uri.Parse("vmess://" + base64.StdEncoding.EncodeToString([]byte(body)))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if n.Proto != "vmess" || n.Host != "198.41.203.3" || n.Port != 443 {
+		t.Fatalf("bad address: %+v", n)
+	}
+	if n.Transport != "ws" || n.Path != "/dongtaiwang.com" || n.HostHeader != "cdn.example.com" {
+		t.Fatalf("bad transport: %+v", n)
+	}
+	if !n.TLS() || n.ServerName() != "sni.example.com" || len(n.ALPN) != 2 || n.Fingerprint != "chrome" {
+		t.Fatalf("bad tls: %+v", n)
+	}
+
+	// numeric port, url-safe unpadded base64, no tls
+	body = `{"add":"1.2.3.4","port":80,"id":"a8c9ad5b-1f58-4234-b5fe-a895bee9a047","aid":"64","net":"tcp","tls":""}`
+	n, err = uri.Parse("vmess://" + base64.RawURLEncoding.EncodeToString([]byte(body)))
+	if err != nil {
+		t.Fatalf("parse numeric: %v", err)
+	}
+	if n.Port != 80 || n.AlterID != 64 || n.TLS() || n.Transport != "tcp" {
+		t.Fatalf("bad numeric node: %+v", n)
+	}
+
+	if _, err := uri.Parse("vmess://not-json"); !errors.Is(err, uri.ErrMalformed) {
+		t.Fatalf("expected malformed, got %v", err)
+	}
+	body = `{"add":"","port":80,"id":"x"}`
+	if _, err := uri.Parse("vmess://" + base64.StdEncoding.EncodeToString([]byte(body))); !errors.Is(err, uri.ErrMissingHost) {
+		t.Fatalf("expected missing host, got %v", err)
+	}
+}
+
+func TestParseVLESSAndTrojan(t *testing.T) {
+	n, err := uri.Parse("vless://0b1e7c9a-1f58-4234-b5fe-a895bee9a047@[2606:4700::1]:8443?encryption=none" +
+		"&security=reality&sni=www.microsoft.com&fp=chrome&pbk=SbVKOEMjK0sIlbwg4akyBg5mL5KZwwB-ed4eEE7YnRc" +
+		"&sid=6ba85179e30d4fc2&type=grpc&serviceName=gun-svc&mode=multi&flow=xtls-rprx-vision#DE%20node 🇩🇪")
+	if err != nil {
+		t.Fatalf("parse vless: %v", err)
+	}
+	if n.Proto != "vless" || n.Host != "2606:4700::1" || n.Port != 8443 || n.UUID == "" {
+		t.Fatalf("bad vless address: %+v", n)
+	}
+	if n.Security != "reality" || n.PublicKey == "" || n.ShortID != "6ba85179e30d4fc2" || n.SNI != "www.microsoft.com" {
+		t.Fatalf("bad vless reality: %+v", n)
+	}
+	if n.Transport != "grpc" || n.ServiceName != "gun-svc" || n.Mode != "multi" || n.Flow != "xtls-rprx-vision" {
+		t.Fatalf("bad vless transport: %+v", n)
+	}
+	if n.Remark != "DE node 🇩🇪" {
+		t.Fatalf("bad remark: %q", n.Remark)
+	}
+
+	n, err = uri.Parse("vless://id@example.com:80?type=ws&path=%2Fws%3Fed%3D2048&host=cdn.example.com#ws")
+	if err != nil {
+		t.Fatalf("parse vless ws: %v", err)
+	}
+	if n.Transport != "ws" || n.Path != "/ws?ed=2048" || n.HostHeader != "cdn.example.com" || n.TLS() {
+		t.Fatalf("bad vless ws: %+v", n)
+	}
+
+	n, err = uri.Parse("trojan://p%40ss@trojan.example.com:443?peer=sni.example.com&alpn=h2,http/1.1#t")
+	if err != nil {
+		t.Fatalf("parse trojan: %v", err)
+	}
+	if n.Password != "p@ss" || !n.TLS() || n.SNI != "sni.example.com" || n.Transport != "tcp" || len(n.ALPN) != 2 {
+		t.Fatalf("bad trojan: %+v", n)
+	}
+
+	if _, err := uri.Parse("vless://example.com:443"); !errors.Is(err, uri.ErrMalformed) {
+		t.Fatalf("expected malformed for missing uuid, got %v", err)
+	}
+}
+
+func TestParseShadowsocks(t *testing.T) {
+	// SIP002 with base64url userinfo and plugin
+	ui := base64.RawURLEncoding.EncodeToString([]byte("chacha20-ietf-poly1305:secret"))
+	n, err := uri.Parse("ss://" + ui + "@ss.example.com:8388/?plugin=obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Dbing.com#tag")
+	if err != nil {
+		t.Fatalf("parse sip002: %v", err)
+	}
+	if n.Cipher != "chacha20-ietf-poly1305" || n.Password != "secret" || n.Host != "ss.example.com" || n.Port != 8388 {
+		t.Fatalf("bad sip002: %+v", n)
+	}
+	if n.Plugin != "obfs-local" || n.PluginOpt("obfs") != "http" || n.PluginOpt("obfs-host") != "bing.com" || n.Remark != "tag" {
+		t.Fatalf("bad plugin: %+v", n)
+	}
+
+	// SIP002 plaintext userinfo (2022 ciphers)
+	n, err = uri.Parse("ss://2022-blake3-aes-128-gcm:YctPZ6U7xPPcU%2Bgp3u%2B0tx%2FtRizJN9K8y%2BuKlW2qjlI%3D@[::1]:443#x")
+	if err != nil {
+		t.Fatalf("parse 2022: %v", err)
+	}
+	if n.Cipher != "2022-blake3-aes-128-gcm" || n.Password != "YctPZ6U7xPPcU+gp3u+0tx/tRizJN9K8y+uKlW2qjlI=" || n.Host != "::1" {
+		t.Fatalf("bad 2022: %+v", n)
+	}
+
+	// legacy: everything base64'd
+	legacy := base64.StdEncoding.EncodeToString([]byte("aes-256-gcm:pa:ss@1.2.3.4:443"))
+	n, err = uri.Parse("ss://" + legacy + "#legacy")
+	if err != nil {
+		t.Fatalf("parse legacy: %v", err)
+	}
+	if n.Cipher != "aes-256-gcm" || n.Password != "pa:ss" || n.Host != "1.2.3.4" || n.Port != 443 {
+		t.Fatalf("bad legacy: %+v", n)
+	}
+
+	var ce *uri.CipherError
+	_, err = uri.Parse("ss://" + base64.StdEncoding.EncodeToString([]byte("rc4-md5:x@1.2.3.4:80")))
+	if !errors.As(err, &ce) || !ce.Insecure {
+		t.Fatalf("expected insecure cipher error, got %v", err)
+	}
+	// vmess json mislabelled as ss
+	_, err = uri.Parse("ss://" + base64.StdEncoding.EncodeToString([]byte(`{"add":"1.2.3.4","port":"80"}`)))
+	if !errors.Is(err, uri.ErrMalformed) {
+		t.Fatalf("expected malformed, got %v", err)
+	}
+}
+
+func TestParseUDPAndLegacySchemes(t *testing.T) {
+	n, err := uri.Parse("hy2://auth%40x@hy.example.com:443,20000-30000/?sni=real.example.com&insecure=1&obfs=salamander&obfs-password=pw#hy")
+	if err != nil {
+		t.Fatalf("parse hy2: %v", err)
+	}
+	if n.Proto != "hysteria2" || n.Port != 443 || n.Ports != "443,20000-30000" || n.Password != "auth@x" {
+		t.Fatalf("bad hy2: %+v", n)
+	}
+	if !n.UDP() || !n.AllowInsecure || n.Obfs != "salamander" || n.ObfsParam != "pw" || n.SNI != "real.example.com" {
+		t.Fatalf("bad hy2 options: %+v", n)
+	}
+
+	n, err = uri.Parse("tuic://2dd61d93-75d8-4da4-ac0e-6aecb1e1ab7c:pw@tuic.example.com:8443?congestion_control=bbr&udp_relay_mode=native&alpn=h3#t")
+	if err != nil {
+		t.Fatalf("parse tuic: %v", err)
+	}
+	if n.Proto != "tuic" || n.UUID == "" || n.Password != "pw" || n.CongestionControl != "bbr" || n.ALPN[0] != "h3" || !n.UDP() {
+		t.Fatalf("bad tuic: %+v", n)
+	}
+
+	n, err = uri.Parse("wireguard://cPriv%2Bkey%3D@162.159.192.1:2408?publickey=bmXOC%2BF1FxEMF9dyiK2H5%2F1SUtzH0JuVo51h2wPfgyo%3D&address=172.16.0.2%2F32,2606:4700::2%2F128&mtu=1280&reserved=1,2,3#wg")
+	if err != nil {
+		t.Fatalf("parse wireguard: %v", err)
+	}
+	if n.Proto != "wireguard" || n.PrivateKey != "cPriv+key=" || n.PublicKey == "" || len(n.LocalAddress) != 2 || n.MTU != 1280 {
+		t.Fatalf("bad wireguard: %+v", n)
+	}
+
+	b64 := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	ssr := "ssr://" + b64("1.2.3.4:8388:auth_aes128_md5:aes-256-cfb:tls1.2_ticket_auth:"+b64("pass")+
+		"/?obfsparam="+b64("bing.com")+"&remarks="+b64("ssr node"))
+	n, err = uri.Parse(ssr)
+	if err != nil {
+		t.Fatalf("parse ssr: %v", err)
+	}
+	if n.Proto != "ssr" || n.Port != 8388 || n.SSRProtocol != "auth_aes128_md5" || n.Obfs != "tls1.2_ticket_auth" ||
+		n.Password != "pass" || n.ObfsParam != "bing.com" || n.Remark != "ssr node" {
+		t.Fatalf("bad ssr: %+v", n)
+	}
+
+	n, err = uri.Parse("socks5://" + b64("user:pw") + "@5.6.7.8:1080#s")
+	if err != nil || n.Username != "user" || n.Password != "pw" || n.Port != 1080 {
+		t.Fatalf("bad socks5: %+v %v", n, err)
+	}
+}
+
+func TestNodeIdentityIgnoresCosmetics(t *testing.T) {
+	a := `{"v":"2","ps":"one","add":"1.2.3.4","port":"443","id":"AAAA-bbbb","net":"ws","path":"/p","tls":"tls","sni":"s.example.com"}`
+	b := `{"sni":"s.example.com","tls":"tls","path":"/p","net":"ws","id":"aaaa-bbbb","port":443,"add":"1.2.3.4","ps":"two"}`
+	na, err := uri.Parse("vmess://" + base64.StdEncoding.EncodeToString([]byte(a)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nb, err := uri.Parse("vmess://" + base64.RawURLEncoding.EncodeToString([]byte(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if na.ID() != nb.ID() {
+		t.Fatalf("vmess variants should share an ID:\n%s\n%s", na.Identity(), nb.Identity())
+	}
+
+	va, _ := uri.Parse("trojan://pw@t.example.com:443?sni=x.example.com&type=ws&path=%2Fa#A")
+	vb, _ := uri.Parse("trojan://pw@T.example.com:443?path=%2Fa&type=ws&sni=x.example.com#B")
+	vc, _ := uri.Parse("trojan://pw@t.example.com:443?sni=x.example.com&type=ws&path=%2Fb#A")
+	if va.ID() != vb.ID() {
+		t.Fatalf("trojan variants should share an ID")
+	}
+	if va.ID() == vc.ID() {
+		t.Fatalf("different path must yield a different ID")
+	}
+
+	// plain ws behind one CDN address: the Host header picks the server
+	ha, _ := uri.Parse("vless://u@104.16.1.1:80?type=ws&path=%2Fws&host=a.example.com#A")
+	hb, _ := uri.Parse("vless://u@104.16.1.1:80?type=ws&path=%2Fws&host=b.example.com#B")
+	hc, _ := uri.Parse("vless://u@104.16.1.1:80?host=A.example.com&path=%2Fws&type=ws#C")
+	if ha.ID() == hb.ID() {
+		t.Fatalf("different Host headers must yield different IDs")
+	}
+	if ha.ID() != hc.ID() {
+		t.Fatalf("Host header case should not matter")
+	}
+}
+
+func TestNodeStringRoundTrip(t *testing.T) {
+	b64 := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	links := []string{
+		"vmess://" + base64.StdEncoding.EncodeToString([]byte(`{"add":"1.2.3.4","port":"443","id":"u","net":"grpc","path":"svc","type":"multi","tls":"tls","sni":"s","ps":"r"}`)),
+		"vless://id@[2606:4700::1]:443?security=reality&pbk=k&sid=ab&sni=www.apple.com&type=tcp&flow=xtls-rprx-vision#v",
+		"trojan://p%40ss@t.example.com:443?type=ws&path=%2Fws&host=h#t",
+		"ss://" + b64("aes-128-gcm:pw") + "@1.2.3.4:8388/?plugin=v2ray-plugin%3Bmode%3Dwebsocket%3Btls#s",
+		"ss://2022-blake3-aes-128-gcm:YctPZ6U7xPPcU%2Bgp3u%2B0tx%2FtRizJN9K8y%2BuKlW2qjlI%3D@1.2.3.4:443#s2",
+		"ssr://" + b64("1.2.3.4:8388:origin:aes-256-cfb:plain:"+b64("pw")+"/?remarks="+b64("r")),
+		"hy2://pw@hy.example.com:443,5000-6000/?obfs=salamander&obfs-password=x#h",
+		"tuic://u:p@tuic.example.com:443?alpn=h3&congestion_control=bbr#t",
+		"wireguard://priv@1.1.1.1:2408?publickey=pub&address=10.0.0.2%2F32&mtu=1280#w",
+		"socks5://user:pw@5.6.7.8:1080#s",
+	}
+	for _, l := range links {
+		a, err := uri.Parse(l)
+		if err != nil {
+			t.Fatalf("parse %s: %v", l, err)
+		}
+		b, err := uri.Parse(a.String())
+		if err != nil {
+			t.Fatalf("reparse %s: %v", a.String(), err)
+		}
+		if a.Identity() != b.Identity() || a.Remark != b.Remark {
+			t.Fatalf("round trip changed node:\n%s\n%s", a.Identity(), b.Identity())
+		}
+		if a.String() != b.String() {
+			t.Fatalf("encoding is not stable:\n%s\n%s", a.String(), b.String())
+		}
+	}
+}